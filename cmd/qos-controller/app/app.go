@@ -3,16 +3,23 @@ package app
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/crazytaxii/volume-qos-controller/cmd/qos-controller/app/config"
 	"github.com/crazytaxii/volume-qos-controller/cmd/qos-controller/app/option"
+	"github.com/crazytaxii/volume-qos-controller/pkg/healthz"
+	"github.com/crazytaxii/volume-qos-controller/pkg/metrics"
 	qc "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller"
 	"github.com/crazytaxii/volume-qos-controller/pkg/signals"
+	"github.com/crazytaxii/volume-qos-controller/pkg/webhook"
 
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
@@ -40,6 +47,20 @@ func NewCommand(version string) *cobra.Command {
 	}
 	opts.AddFlags(runCmd)
 
+	webhookCmd := &cobra.Command{
+		Use:     "webhook",
+		Short:   "Launch a webhook server validating the QoS annotations of PVCs",
+		Long:    "webhook subcommand will launch an admission webhook server validating the QoS annotations of PVCs",
+		Example: "qos-controller webhook --config-file=/path/to/config.yaml --cert-dir=/path/to/certs",
+		Run: func(_ *cobra.Command, _ []string) {
+			if err := runWebhook(signals.SetupSignalHandler(), opts); err != nil {
+				klog.Error(err)
+				os.Exit(1)
+			}
+		},
+	}
+	opts.AddWebhookFlags(webhookCmd)
+
 	verCmd := &cobra.Command{
 		Use:     "version",
 		Short:   "Print version and exit",
@@ -50,7 +71,7 @@ func NewCommand(version string) *cobra.Command {
 		},
 	}
 
-	cmd.AddCommand(runCmd, verCmd)
+	cmd.AddCommand(runCmd, webhookCmd, verCmd)
 	return cmd
 }
 
@@ -68,11 +89,47 @@ func run(ctx context.Context, opts *option.Options) error {
 		return err
 	}
 
-	ctrl, err := qc.NewQosController(kubeClient, cfg.ControllerConfig)
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return err
+	}
+
+	ctrl, err := qc.NewQosController(kubeClient, dynamicClient, cfg.ControllerConfig)
 	if err != nil {
 		return err
 	}
 
+	// Serve metrics whether leading or not.
+	if addr := cfg.MetricsBindAddress; addr != "" && addr != "0" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go serveHTTP(ctx, addr, mux)
+	}
+
+	// Standby replicas are always ready, otherwise they would never be
+	// available without leading.
+	var leading atomic.Bool
+	leading.Store(!cfg.LeaderElect)
+	if addr := cfg.HealthProbeBindAddress; addr != "" && addr != "0" {
+		ifLeading := func(check func() error) func() error {
+			return func() error {
+				if !leading.Load() {
+					return nil
+				}
+				return check()
+			}
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", healthz.Handler(
+			healthz.Check{Name: "workers", Check: ctrl.CheckWorkers},
+		))
+		mux.Handle("/readyz", healthz.Handler(
+			healthz.Check{Name: "informer-sync", Check: ifLeading(ctrl.CheckCacheSync)},
+			healthz.Check{Name: "volume-managers", Check: ifLeading(ctrl.CheckVolumeManagers)},
+		))
+		go serveHTTP(ctx, addr, mux)
+	}
+
 	if cfg.LeaderElect {
 		hostname, err := os.Hostname()
 		if err != nil {
@@ -102,12 +159,14 @@ func run(ctx context.Context, opts *option.Options) error {
 			RetryPeriod:     cfg.RetryPeriod,
 			Callbacks: leaderelection.LeaderCallbacks{
 				OnStartedLeading: func(ctx context.Context) {
+					leading.Store(true)
 					if err := ctrl.Run(ctx.Done()); err != nil {
 						klog.Errorf("error running QoS controller: %v", err)
 					}
 					cancel()
 				},
 				OnStoppedLeading: func() {
+					leading.Store(false)
 					klog.Errorf("leader lost: %s", id)
 				},
 				OnNewLeader: func(identity string) {
@@ -129,3 +188,50 @@ func run(ctx context.Context, opts *option.Options) error {
 	}
 	return nil
 }
+
+func runWebhook(ctx context.Context, opts *option.Options) error {
+	cfg, err := opts.Config()
+	if err != nil {
+		return err
+	}
+	kubeConfig, err := config.BuildKubeConfig()
+	if err != nil {
+		return err
+	}
+	kubeClient, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return err
+	}
+
+	// The volume managers are only used to validate the QoS settings, so
+	// there is no need to connect them nor to list the StorageClasses.
+	managers, err := cfg.InitVolumeManagers(kubeClient, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := webhook.NewServer(kubeClient, managers, cfg.Webhook).Run(ctx.Done()); err != nil {
+		return fmt.Errorf("error running webhook server: %v", err)
+	}
+	return nil
+}
+
+// serveHTTP serves the handler on the address until the context is done.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(c)
+	}()
+
+	klog.Infof("Serving HTTP on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		klog.Errorf("error serving HTTP on %s: %v", addr, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	qc "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller"
+	"github.com/crazytaxii/volume-qos-controller/pkg/webhook"
 
 	"github.com/spf13/viper"
 	"k8s.io/client-go/rest"
@@ -58,6 +59,7 @@ type (
 	Config struct {
 		*LeaderElection      `json:"leader_election" yaml:"leaderElection"`
 		*qc.ControllerConfig `json:"controller_config" yaml:"controllerConfig"`
+		Webhook              *webhook.Config `json:"webhook" yaml:"webhook"`
 	}
 )
 
@@ -76,6 +78,7 @@ func NewDefaultConfig() *Config {
 	return &Config{
 		LeaderElection:   DefaultLeaderElection(),
 		ControllerConfig: qc.DefaultControllerConfig(),
+		Webhook:          webhook.DefaultConfig(),
 	}
 }
 
@@ -3,6 +3,7 @@ package option
 import (
 	"github.com/crazytaxii/volume-qos-controller/cmd/qos-controller/app/config"
 	qc "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller"
+	"github.com/crazytaxii/volume-qos-controller/pkg/webhook"
 
 	"github.com/spf13/cobra"
 )
@@ -11,12 +12,14 @@ type Options struct {
 	ConfigFile string
 	*config.LeaderElection
 	*qc.ControllerConfig
+	Webhook *webhook.Config
 }
 
 func NewOptions() *Options {
 	return &Options{
 		LeaderElection:   config.DefaultLeaderElection(),
 		ControllerConfig: qc.DefaultControllerConfig(),
+		Webhook:          webhook.DefaultConfig(),
 	}
 }
 
@@ -54,6 +57,12 @@ func (o *Options) AddFlags(cmd *cobra.Command) {
 	o.AddControllerConfigFlags(cmd.Flags())
 }
 
+func (o *Options) AddWebhookFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.ConfigFile, "config-file", "", "", "config file path will be read in")
+
+	o.Webhook.AddFlags(cmd.Flags())
+}
+
 func (o *Options) Config() (*config.Config, error) {
 	if o.ConfigFile != "" {
 		return config.LoadConfigFile(o.ConfigFile)
@@ -61,5 +70,6 @@ func (o *Options) Config() (*config.Config, error) {
 	return &config.Config{
 		LeaderElection:   o.LeaderElection,
 		ControllerConfig: o.ControllerConfig,
+		Webhook:          o.Webhook,
 	}, nil
 }
@@ -0,0 +1,18 @@
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	GroupName = "qos.crazytaxii.io"
+	Version   = "v1alpha1"
+)
+
+var (
+	// SchemeGroupVersion is group version used to register these objects.
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: Version}
+
+	VolumeQoSPolicyResource = SchemeGroupVersion.WithResource("volumeqospolicies")
+	VolumeQoSClassResource  = SchemeGroupVersion.WithResource("volumeqosclasses")
+)
@@ -0,0 +1,205 @@
+package v1alpha1
+
+import (
+	"strconv"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionApplied indicates whether the QoS settings have been applied to the volume.
+	ConditionApplied = "Applied"
+
+	ReasonApplied          = "Applied"
+	ReasonInvalidQoS       = "InvalidQoS"
+	ReasonSettingQoSFailed = "SettingQoSFailed"
+
+	ReasonExceedsNamespaceMax = "ExceedsNamespaceMax"
+)
+
+type (
+	// QoSSpec describes the limits and bursts of a volume. IOPS values are
+	// numbers of I/Os per second, BPS values are bytes per second, and burst
+	// seconds are how long the bursts last.
+	QoSSpec struct {
+		IOPSLimit      *int64 `json:"iopsLimit,omitempty"`
+		ReadIOPSLimit  *int64 `json:"readIOPSLimit,omitempty"`
+		WriteIOPSLimit *int64 `json:"writeIOPSLimit,omitempty"`
+
+		IOPSBurst      *int64 `json:"iopsBurst,omitempty"`
+		ReadIOPSBurst  *int64 `json:"readIOPSBurst,omitempty"`
+		WriteIOPSBurst *int64 `json:"writeIOPSBurst,omitempty"`
+
+		BPSLimit      *resource.Quantity `json:"bpsLimit,omitempty"`
+		ReadBPSLimit  *resource.Quantity `json:"readBPSLimit,omitempty"`
+		WriteBPSLimit *resource.Quantity `json:"writeBPSLimit,omitempty"`
+
+		BPSBurst      *resource.Quantity `json:"bpsBurst,omitempty"`
+		ReadBPSBurst  *resource.Quantity `json:"readBPSBurst,omitempty"`
+		WriteBPSBurst *resource.Quantity `json:"writeBPSBurst,omitempty"`
+
+		IOPSBurstSeconds      *int64 `json:"iopsBurstSeconds,omitempty"`
+		ReadIOPSBurstSeconds  *int64 `json:"readIOPSBurstSeconds,omitempty"`
+		WriteIOPSBurstSeconds *int64 `json:"writeIOPSBurstSeconds,omitempty"`
+
+		BPSBurstSeconds      *int64 `json:"bpsBurstSeconds,omitempty"`
+		ReadBPSBurstSeconds  *int64 `json:"readBPSBurstSeconds,omitempty"`
+		WriteBPSBurstSeconds *int64 `json:"writeBPSBurstSeconds,omitempty"`
+
+		// The per-GiB settings set the limits in proportion to the capacity
+		// of the volume, bounded by the absolute min and max.
+		IOPSPerGiB    *int64 `json:"iopsPerGiB,omitempty"`
+		IOPSPerGiBMin *int64 `json:"iopsPerGiBMin,omitempty"`
+		IOPSPerGiBMax *int64 `json:"iopsPerGiBMax,omitempty"`
+
+		BPSPerGiB    *resource.Quantity `json:"bpsPerGiB,omitempty"`
+		BPSPerGiBMin *resource.Quantity `json:"bpsPerGiBMin,omitempty"`
+		BPSPerGiBMax *resource.Quantity `json:"bpsPerGiBMax,omitempty"`
+	}
+
+	// VolumeQoSPolicy applies QoS settings to the PVCs selected in its namespace.
+	VolumeQoSPolicy struct {
+		metav1.TypeMeta   `json:",inline"`
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+
+		Spec   VolumeQoSPolicySpec   `json:"spec"`
+		Status VolumeQoSPolicyStatus `json:"status,omitempty"`
+	}
+	VolumeQoSPolicySpec struct {
+		// Selector selects the PVCs in the namespace of the policy, a nil
+		// selector selects all of them.
+		Selector *metav1.LabelSelector `json:"selector,omitempty"`
+		QoSSpec  `json:",inline"`
+		// Schedules override the QoS settings of the policy in their windows.
+		Schedules []QoSScheduleSpec `json:"schedules,omitempty"`
+	}
+	// QoSScheduleSpec applies the QoS settings in the windows which start at
+	// the times of the cron schedule and last for the duration.
+	QoSScheduleSpec struct {
+		// Schedule is a standard cron expression of the starts of the windows.
+		Schedule string          `json:"schedule"`
+		Duration metav1.Duration `json:"duration"`
+		// TimeZone is the IANA time zone of the schedule, UTC by default.
+		TimeZone string `json:"timeZone,omitempty"`
+		QoSSpec  `json:",inline"`
+	}
+	VolumeQoSPolicyStatus struct {
+		ObservedGeneration int64         `json:"observedGeneration,omitempty"`
+		Claims             []ClaimStatus `json:"claims,omitempty"`
+	}
+	// ClaimStatus reports the state of the QoS settings of a selected PVC.
+	ClaimStatus struct {
+		Name       string             `json:"name"`
+		Conditions []metav1.Condition `json:"conditions,omitempty"`
+	}
+
+	// VolumeQoSClass is a cluster-scoped named set of QoS settings, which is
+	// referenced by PVCs through the qos-class annotation.
+	VolumeQoSClass struct {
+		metav1.TypeMeta   `json:",inline"`
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+
+		Spec QoSSpec `json:"spec"`
+	}
+)
+
+// Settings converts the QoS spec into QoS settings keyed by the annotation keys.
+func (s *QoSSpec) Settings() vm.QoSSettings {
+	settings := make(vm.QoSSettings)
+
+	for key, v := range map[string]*int64{
+		vm.QoSLimitIOPSKey:      s.IOPSLimit,
+		vm.QoSLimitReadIOPSKey:  s.ReadIOPSLimit,
+		vm.QoSLimitWriteIOPSKey: s.WriteIOPSLimit,
+
+		vm.QoSBurstIOPSKey:      s.IOPSBurst,
+		vm.QoSBurstReadIOPSKey:  s.ReadIOPSBurst,
+		vm.QoSBurstWriteIOPSKey: s.WriteIOPSBurst,
+
+		vm.QoSBurstSecondsIOPSKey:      s.IOPSBurstSeconds,
+		vm.QoSBurstSecondsReadIOPSKey:  s.ReadIOPSBurstSeconds,
+		vm.QoSBurstSecondsWriteIOPSKey: s.WriteIOPSBurstSeconds,
+
+		vm.QoSBurstSecondsBPSKey:      s.BPSBurstSeconds,
+		vm.QoSBurstSecondsReadBPSKey:  s.ReadBPSBurstSeconds,
+		vm.QoSBurstSecondsWriteBPSKey: s.WriteBPSBurstSeconds,
+
+		vm.QoSIOPSPerGiBKey:    s.IOPSPerGiB,
+		vm.QoSIOPSPerGiBMinKey: s.IOPSPerGiBMin,
+		vm.QoSIOPSPerGiBMaxKey: s.IOPSPerGiBMax,
+	} {
+		if v != nil {
+			settings[key] = strconv.FormatInt(*v, 10)
+		}
+	}
+
+	for key, q := range map[string]*resource.Quantity{
+		vm.QoSLimitBPSKey:      s.BPSLimit,
+		vm.QoSLimitReadBPSKey:  s.ReadBPSLimit,
+		vm.QoSLimitWriteBPSKey: s.WriteBPSLimit,
+
+		vm.QoSBurstBPSKey:      s.BPSBurst,
+		vm.QoSBurstReadBPSKey:  s.ReadBPSBurst,
+		vm.QoSBurstWriteBPSKey: s.WriteBPSBurst,
+
+		vm.QoSBPSPerGiBKey:    s.BPSPerGiB,
+		vm.QoSBPSPerGiBMinKey: s.BPSPerGiBMin,
+		vm.QoSBPSPerGiBMaxKey: s.BPSPerGiBMax,
+	} {
+		if q != nil {
+			settings[key] = strconv.FormatInt(q.Value(), 10)
+		}
+	}
+
+	return settings
+}
+
+// QoSSchedules converts the schedules of the policy into QoS schedules.
+func (s *VolumeQoSPolicySpec) QoSSchedules() []vm.QoSSchedule {
+	schedules := make([]vm.QoSSchedule, 0, len(s.Schedules))
+	for i := range s.Schedules {
+		schedules = append(schedules, vm.QoSSchedule{
+			Schedule: s.Schedules[i].Schedule,
+			Duration: s.Schedules[i].Duration.Duration,
+			TimeZone: s.Schedules[i].TimeZone,
+			Settings: s.Schedules[i].Settings(),
+		})
+	}
+	return schedules
+}
+
+// ClaimStatus returns the status of the named PVC, or nil if it is not reported.
+func (s *VolumeQoSPolicyStatus) ClaimStatus(name string) *ClaimStatus {
+	for i := range s.Claims {
+		if s.Claims[i].Name == name {
+			return &s.Claims[i]
+		}
+	}
+	return nil
+}
+
+// RemoveClaimStatus removes the status of the named PVC, it reports whether
+// the status has been changed.
+func (s *VolumeQoSPolicyStatus) RemoveClaimStatus(name string) bool {
+	for i := range s.Claims {
+		if s.Claims[i].Name == name {
+			s.Claims = append(s.Claims[:i], s.Claims[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DeepCopy returns a deep copy of the status.
+func (s *VolumeQoSPolicyStatus) DeepCopy() *VolumeQoSPolicyStatus {
+	out := &VolumeQoSPolicyStatus{ObservedGeneration: s.ObservedGeneration}
+	for _, cs := range s.Claims {
+		conds := make([]metav1.Condition, len(cs.Conditions))
+		copy(conds, cs.Conditions)
+		out.Claims = append(out.Claims, ClaimStatus{Name: cs.Name, Conditions: conds})
+	}
+	return out
+}
@@ -0,0 +1,47 @@
+package v1alpha1
+
+import (
+	"reflect"
+	"testing"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestQoSSpec_Settings(t *testing.T) {
+	iops := int64(1000)
+	seconds := int64(30)
+	bps := resource.MustParse("100Mi")
+	tests := []struct {
+		name string
+		spec QoSSpec
+		want vm.QoSSettings
+	}{
+		{
+			name: "none",
+			spec: QoSSpec{},
+			want: vm.QoSSettings{},
+		},
+		{
+			name: "mixed",
+			spec: QoSSpec{
+				IOPSLimit:       &iops,
+				ReadBPSBurst:    &bps,
+				BPSBurstSeconds: &seconds,
+			},
+			want: vm.QoSSettings{
+				vm.QoSLimitIOPSKey:       "1000",
+				vm.QoSBurstReadBPSKey:    "104857600",
+				vm.QoSBurstSecondsBPSKey: "30",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.Settings(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Settings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
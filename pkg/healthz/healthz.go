@@ -0,0 +1,41 @@
+package healthz
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// Check is a named health check.
+type Check struct {
+	Name  string
+	Check func() error
+}
+
+// Handler returns an HTTP handler running all the checks, which responds 200
+// if all of them pass, 500 otherwise. The result of each check is written in
+// the body, like the health endpoints of Kubernetes components.
+func Handler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var buf bytes.Buffer
+		failed := false
+		for _, c := range checks {
+			if err := c.Check(); err != nil {
+				failed = true
+				fmt.Fprintf(&buf, "[-]%s failed: %v\n", c.Name, err)
+				continue
+			}
+			fmt.Fprintf(&buf, "[+]%s ok\n", c.Name)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			buf.WriteString("healthz check failed\n")
+		} else {
+			buf.WriteString("ok\n")
+		}
+		_, _ = w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,49 @@
+package healthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	ok := Check{Name: "ok", Check: func() error { return nil }}
+	bad := Check{Name: "bad", Check: func() error { return errors.New("boom") }}
+	tests := []struct {
+		name     string
+		checks   []Check
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "none",
+			wantCode: http.StatusOK,
+			wantBody: "ok\n",
+		},
+		{
+			name:     "pass",
+			checks:   []Check{ok},
+			wantCode: http.StatusOK,
+			wantBody: "[+]ok ok\nok\n",
+		},
+		{
+			name:     "fail",
+			checks:   []Check{ok, bad},
+			wantCode: http.StatusInternalServerError,
+			wantBody: "[+]ok ok\n[-]bad failed: boom\nhealthz check failed\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Handler(tt.checks...).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("Handler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("Handler() body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
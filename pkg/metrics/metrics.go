@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "qos_controller"
+
+	// The results of reconciling a PVC.
+	ResultApplied                = "applied"
+	ResultCleanedUp              = "cleaned-up"
+	ResultDryRun                 = "dry-run"
+	ResultNotFound               = "skipped-not-found"
+	ResultUnbound                = "skipped-unbound"
+	ResultUnsupportedProvisioner = "skipped-unsupported-provisioner"
+	ResultMissingAnnotation      = "skipped-missing-annotation"
+	ResultInvalid                = "invalid"
+	ResultBackendError           = "backend-error"
+	ResultError                  = "error"
+)
+
+var (
+	// Registry is the registry of all the metrics exposed by the controller.
+	Registry = prometheus.NewRegistry()
+
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Total number of PVC reconciliations by result.",
+	}, []string{"result"})
+
+	SetQoSDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "set_qos_duration_seconds",
+		Help:      "Latency of setting the QoS of a volume by volume manager.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provisioner"})
+
+	ManagedVolumes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "managed_volumes",
+		Help:      "Number of PVCs under QoS by volume manager.",
+	}, []string{"provisioner"})
+
+	BackendConnected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backend_connected",
+		Help:      "Whether the volume manager is connected to its storage backend by cluster.",
+	}, []string{"provisioner", "cluster"})
+
+	BackendReconnectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_reconnects_total",
+		Help:      "Total number of attempts to reconnect to the storage backend by volume manager and cluster.",
+	}, []string{"provisioner", "cluster"})
+
+	DriftCorrectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "drift_corrected_total",
+		Help:      "Total number of volumes whose QoS rules drifted in the backend and have been corrected by volume manager.",
+	}, []string{"provisioner"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ReconcileTotal,
+		SetQoSDuration,
+		ManagedVolumes,
+		BackendConnected,
+		BackendReconnectsTotal,
+		DriftCorrectedTotal,
+	)
+}
+
+// Handler returns the HTTP handler exposing the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+var (
+	volumesMu sync.Mutex
+	// volumes maps the key of each PVC under QoS to its provisioner.
+	volumes = make(map[string]string)
+)
+
+// TrackVolume updates the ManagedVolumes gauge with whether the PVC of the
+// provisioner is under QoS.
+func TrackVolume(provisioner, key string, managed bool) {
+	volumesMu.Lock()
+	defer volumesMu.Unlock()
+
+	if old, ok := volumes[key]; ok {
+		delete(volumes, key)
+		ManagedVolumes.WithLabelValues(old).Dec()
+	}
+	if managed {
+		volumes[key] = provisioner
+		ManagedVolumes.WithLabelValues(provisioner).Inc()
+	}
+}
+
+// UntrackVolume removes the PVC from the ManagedVolumes gauge.
+func UntrackVolume(key string) {
+	TrackVolume("", key, false)
+}
@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTrackVolume(t *testing.T) {
+	TrackVolume("rbd.csi.ceph.com", "demo/a", true)
+	TrackVolume("rbd.csi.ceph.com", "demo/b", true)
+	TrackVolume("rbd.csi.ceph.com", "demo/b", true)
+	TrackVolume("other.csi.k8s.io", "demo/c", true)
+	TrackVolume("rbd.csi.ceph.com", "demo/c", true)
+	TrackVolume("rbd.csi.ceph.com", "demo/a", false)
+	UntrackVolume("demo/missing")
+
+	if got := testutil.ToFloat64(ManagedVolumes.WithLabelValues("rbd.csi.ceph.com")); got != 2 {
+		t.Errorf("managed volumes of rbd.csi.ceph.com = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(ManagedVolumes.WithLabelValues("other.csi.k8s.io")); got != 0 {
+		t.Errorf("managed volumes of other.csi.k8s.io = %v, want 0", got)
+	}
+}
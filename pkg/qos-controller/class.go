@@ -0,0 +1,65 @@
+package qoscontroller
+
+import (
+	"fmt"
+
+	qosv1alpha1 "github.com/crazytaxii/volume-qos-controller/pkg/apis/qos/v1alpha1"
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// qosClassIndex indexes PVCs by the name of the VolumeQoSClass they reference.
+const qosClassIndex = "qosClass"
+
+// qosClassIndexFunc is the index function of qosClassIndex.
+func qosClassIndexFunc(obj interface{}) ([]string, error) {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return nil, nil
+	}
+	if class := pvc.Annotations[vm.QoSClassKey]; class != "" {
+		return []string{class}, nil
+	}
+	return nil, nil
+}
+
+// toClass converts an unstructured object from the informer into a VolumeQoSClass.
+func toClass(obj interface{}) (*qosv1alpha1.VolumeQoSClass, error) {
+	class := &qosv1alpha1.VolumeQoSClass{}
+	if err := fromUnstructured(obj, class); err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+// enqueueClassPVCs enqueues all the PVCs referencing the VolumeQoSClass.
+func (c *VolumeQoSController) enqueueClassPVCs(obj interface{}) {
+	class, err := toClass(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	pvcs, err := c.pvcInformer.Informer().GetIndexer().ByIndex(qosClassIndex, class.Name)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, pvc := range pvcs {
+		c.enqueuePVC(pvc)
+	}
+}
+
+// getClass returns the VolumeQoSClass with the given name from the informer cache.
+func (c *VolumeQoSController) getClass(name string) (*qosv1alpha1.VolumeQoSClass, error) {
+	obj, err := c.classLister.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	class, err := toClass(obj)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VolumeQoSClass %s: %v", name, err)
+	}
+	return class, nil
+}
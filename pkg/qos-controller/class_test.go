@@ -0,0 +1,140 @@
+package qoscontroller
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	qosv1alpha1 "github.com/crazytaxii/volume-qos-controller/pkg/apis/qos/v1alpha1"
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newPVCInformer returns a PVC informer indexed like the controller's one,
+// whose cache holds the PVCs.
+func newPVCInformer(t *testing.T, pvcs ...*corev1.PersistentVolumeClaim) coreinformers.PersistentVolumeClaimInformer {
+	t.Helper()
+	informer := kubeinformers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().PersistentVolumeClaims()
+	if err := informer.Informer().AddIndexers(cache.Indexers{
+		qosClassIndex:     qosClassIndexFunc,
+		storageClassIndex: storageClassIndexFunc,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, pvc := range pvcs {
+		if err := informer.Informer().GetIndexer().Add(pvc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return informer
+}
+
+// toUnstructured converts the object into an unstructured one as the dynamic
+// informers return.
+func toUnstructured(t *testing.T, obj interface{}) *unstructured.Unstructured {
+	t.Helper()
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+func Test_qosClassIndexFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []string
+	}{
+		{
+			name: "class",
+			obj: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{vm.QoSClassKey: "gold"},
+			}},
+			want: []string{"gold"},
+		},
+		{
+			name: "empty class",
+			obj: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{vm.QoSClassKey: ""},
+			}},
+		},
+		{
+			name: "no class",
+			obj:  &corev1.PersistentVolumeClaim{},
+		},
+		{
+			name: "not PVC",
+			obj:  &corev1.PersistentVolume{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := qosClassIndexFunc(tt.obj)
+			if err != nil {
+				t.Fatalf("qosClassIndexFunc() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("qosClassIndexFunc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeQoSController_enqueueClassPVCs(t *testing.T) {
+	newPVC := func(namespace, name, class string) *corev1.PersistentVolumeClaim {
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		if class != "" {
+			pvc.Annotations = map[string]string{vm.QoSClassKey: class}
+		}
+		return pvc
+	}
+	pvcInformer := newPVCInformer(t,
+		newPVC("demo", "gold-1", "gold"),
+		newPVC("demo", "silver-1", "silver"),
+		newPVC("other", "gold-2", "gold"),
+		newPVC("demo", "plain", ""),
+	)
+	newClass := func(name string) *unstructured.Unstructured {
+		return toUnstructured(t, &qosv1alpha1.VolumeQoSClass{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []interface{}
+	}{
+		{
+			name: "referenced",
+			obj:  newClass("gold"),
+			want: []interface{}{"demo/gold-1", "other/gold-2"},
+		},
+		{
+			name: "deleted",
+			obj:  cache.DeletedFinalStateUnknown{Key: "silver", Obj: newClass("silver")},
+			want: []interface{}{"demo/silver-1"},
+		},
+		{
+			name: "unreferenced",
+			obj:  newClass("bronze"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &fakeQueue{}
+			c := &VolumeQoSController{pvcInformer: pvcInformer, workqueue: q}
+			c.enqueueClassPVCs(tt.obj)
+			sort.Slice(q.added, func(i, j int) bool { return q.added[i].(string) < q.added[j].(string) })
+			if !reflect.DeepEqual(q.added, tt.want) {
+				t.Errorf("enqueueClassPVCs() enqueued %v, want %v", q.added, tt.want)
+			}
+		})
+	}
+}
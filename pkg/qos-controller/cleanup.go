@@ -0,0 +1,130 @@
+package qoscontroller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/crazytaxii/volume-qos-controller/pkg/metrics"
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// QoSCleanupFinalizer holds the PVC from disappearing until the QoS rules
+	// of its volume have been removed.
+	QoSCleanupFinalizer = "qos.crazytaxii.io/cleanup"
+
+	// CleanupModeNever never removes the QoS rules of the volumes.
+	CleanupModeNever = "never"
+	// CleanupModeRetained removes the QoS rules of the volumes whose PV
+	// reclaim policy is not Delete, so the reused volumes are not limited.
+	CleanupModeRetained = "retained"
+	// CleanupModeAlways removes the QoS rules of all the volumes.
+	CleanupModeAlways = "always"
+)
+
+// shouldCleanup reports whether the QoS rules of the volume of the PV should
+// be removed on the deletion of its PVC.
+func (c *VolumeQoSController) shouldCleanup(pv *corev1.PersistentVolume) bool {
+	switch c.CleanupMode {
+	case CleanupModeAlways:
+		return true
+	case CleanupModeRetained:
+		return pv.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete
+	default:
+		return false
+	}
+}
+
+// cleanup removes the QoS rules of the volume of the PVC being deleted if
+// necessary, and then releases the PVC by removing the finalizer. In the dry
+// run, the PVC is released without removing the rules, so that the deletion
+// is never blocked.
+func (c *VolumeQoSController) cleanup(key string, pvc *corev1.PersistentVolumeClaim) error {
+	pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if pv != nil && c.shouldCleanup(pv) {
+		if manager, ok := c.volManagers[volumeProvisioner(pvc, pv)]; ok {
+			applied, err := manager.SetQoS(pv, vm.QoSSettings{})
+			if err != nil {
+				if _, ok := err.(vm.ErrInvalidArgs); !ok {
+					return fmt.Errorf("failed to remove the QoS rules of PVC %s: %v", key, err)
+				}
+				// Retrying is of no use, e.g. the volume is not managed.
+				klog.Warningf("Skip removing the QoS rules of PVC %s: %v", key, err)
+			} else if applied.Changed && c.DryRun {
+				klog.Infof("[dry-run] Skip removing the QoS rules %v of %s volume %s of PVC %s", applied.Removed,
+					applied.Backend, applied.Volume, key)
+				c.recorder.Eventf(pvc, corev1.EventTypeNormal, "QoSDryRun", "QoS rules %v would be removed from %s volume %s, "+
+					"skipped in the dry run", applied.Removed, applied.Backend, applied.Volume)
+			} else if applied.Changed {
+				klog.Infof("Removed the QoS rules of %s volume %s of PVC %s", applied.Backend, applied.Volume, key)
+				c.recorder.Eventf(pvc, corev1.EventTypeNormal, "QoSRemoved", "QoS rules removed from %s volume %s",
+					applied.Backend, applied.Volume)
+			}
+		}
+	}
+	c.drifted.Delete(key)
+	metrics.UntrackVolume(key)
+	return c.setCleanupFinalizer(pvc, false)
+}
+
+// setCleanupFinalizer adds or removes the cleanup finalizer of the PVC.
+func (c *VolumeQoSController) setCleanupFinalizer(pvc *corev1.PersistentVolumeClaim, add bool) error {
+	finalizers := make([]string, 0, len(pvc.Finalizers)+1)
+	found := false
+	for _, f := range pvc.Finalizers {
+		if f == QoSCleanupFinalizer {
+			found = true
+			if !add {
+				continue
+			}
+		}
+		finalizers = append(finalizers, f)
+	}
+	if found == add {
+		return nil
+	}
+	if add {
+		finalizers = append(finalizers, QoSCleanupFinalizer)
+	}
+	if c.DryRun && add {
+		// The finalizer is still removed in the dry run, otherwise the
+		// deletion of the PVC is blocked.
+		klog.Infof("[dry-run] would add finalizer %s to PVC %s/%s", QoSCleanupFinalizer, pvc.Namespace, pvc.Name)
+		return nil
+	}
+
+	// The resource version makes the patch fail on conflicts, since the
+	// finalizers are replaced as a whole.
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": pvc.ResourceVersion,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(context.TODO(), pvc.Name,
+		types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// hasCleanupFinalizer checks if the PVC has the cleanup finalizer.
+func hasCleanupFinalizer(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, f := range pvc.Finalizers {
+		if f == QoSCleanupFinalizer {
+			return true
+		}
+	}
+	return false
+}
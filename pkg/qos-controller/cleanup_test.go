@@ -0,0 +1,146 @@
+package qoscontroller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// fakeRemover is a volume manager recording the settings set.
+type fakeRemover struct {
+	fakeManager
+	set *[]vm.QoSSettings
+}
+
+func (m fakeRemover) SetQoS(_ *corev1.PersistentVolume, settings vm.QoSSettings) (*vm.QoSResult, error) {
+	*m.set = append(*m.set, settings)
+	return &vm.QoSResult{Changed: true}, nil
+}
+
+func TestVolumeQoSController_shouldCleanup(t *testing.T) {
+	newPV := func(policy corev1.PersistentVolumeReclaimPolicy) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{Spec: corev1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: policy}}
+	}
+	tests := []struct {
+		name string
+		mode string
+		pv   *corev1.PersistentVolume
+		want bool
+	}{
+		{
+			name: "never",
+			mode: CleanupModeNever,
+			pv:   newPV(corev1.PersistentVolumeReclaimRetain),
+		},
+		{
+			name: "retained retain",
+			mode: CleanupModeRetained,
+			pv:   newPV(corev1.PersistentVolumeReclaimRetain),
+			want: true,
+		},
+		{
+			name: "retained delete",
+			mode: CleanupModeRetained,
+			pv:   newPV(corev1.PersistentVolumeReclaimDelete),
+		},
+		{
+			name: "always delete",
+			mode: CleanupModeAlways,
+			pv:   newPV(corev1.PersistentVolumeReclaimDelete),
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &VolumeQoSController{ControllerConfig: &ControllerConfig{CleanupMode: tt.mode}}
+			if got := c.shouldCleanup(tt.pv); got != tt.want {
+				t.Errorf("shouldCleanup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeQoSController_cleanup(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name           string
+		reclaim        corev1.PersistentVolumeReclaimPolicy
+		dryRun         bool
+		wantRemoved    bool
+		wantFinalizers []string
+	}{
+		{
+			name:           "retained",
+			reclaim:        corev1.PersistentVolumeReclaimRetain,
+			wantRemoved:    true,
+			wantFinalizers: []string{"kubernetes.io/pvc-protection"},
+		},
+		{
+			name:           "deleted",
+			reclaim:        corev1.PersistentVolumeReclaimDelete,
+			wantFinalizers: []string{"kubernetes.io/pvc-protection"},
+		},
+		{
+			name:           "dry run",
+			reclaim:        corev1.PersistentVolumeReclaimRetain,
+			dryRun:         true,
+			wantRemoved:    true,
+			wantFinalizers: []string{"kubernetes.io/pvc-protection"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "datavol",
+					Namespace:         "demo",
+					Annotations:       map[string]string{vm.AnnStorageProvisioner: "rbd.csi.ceph.com"},
+					Finalizers:        []string{"kubernetes.io/pvc-protection", QoSCleanupFinalizer},
+					DeletionTimestamp: &now,
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{VolumeName: "pv-1"},
+			}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if err := indexer.Add(&corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+				Spec:       corev1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: tt.reclaim},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			var set []vm.QoSSettings
+			client := fake.NewSimpleClientset(pvc)
+			c := &VolumeQoSController{
+				kubeClient:       client,
+				pvLister:         corelisters.NewPersistentVolumeLister(indexer),
+				recorder:         record.NewFakeRecorder(10),
+				volManagers:      map[string]vm.VolumeManager{"rbd.csi.ceph.com": fakeRemover{set: &set}},
+				ControllerConfig: &ControllerConfig{CleanupMode: CleanupModeRetained, DryRun: tt.dryRun},
+			}
+			if err := c.cleanup("demo/datavol", pvc); err != nil {
+				t.Fatalf("cleanup() error = %v", err)
+			}
+			if removed := len(set) > 0; removed != tt.wantRemoved {
+				t.Errorf("cleanup() removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			if tt.wantRemoved && len(set[0]) != 0 {
+				t.Errorf("cleanup() set %v, want no QoS settings", set[0])
+			}
+			got, err := client.CoreV1().PersistentVolumeClaims("demo").Get(context.TODO(), "datavol", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Finalizers, tt.wantFinalizers) {
+				t.Errorf("cleanup() finalizers = %v, want %v", got.Finalizers, tt.wantFinalizers)
+			}
+		})
+	}
+}
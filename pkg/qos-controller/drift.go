@@ -0,0 +1,115 @@
+package qoscontroller
+
+import (
+	"context"
+	"fmt"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// checkDrift compares the QoS rules of the volumes in the backends with the
+// applied ones recorded on the PVCs, and requeues the drifted PVCs to correct
+// them. The informer resyncs do not help since the PVCs are unchanged.
+// The rules are read by as many goroutines as the workers, so that a slow
+// backend does not hold up the check of all the volumes.
+func (c *VolumeQoSController) checkDrift(stopCh <-chan struct{}) {
+	pvcs, err := c.pvcLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	workqueue.ParallelizeUntil(ctx, workers, len(pvcs), func(i int) {
+		if err := c.checkPVCDrift(pvcs[i]); err != nil {
+			utilruntime.HandleError(err)
+		}
+	})
+}
+
+// checkPVCDrift checks whether the QoS rules of the volume of the PVC have
+// drifted from the applied ones, the drifted rules are recorded until the
+// PVC has been processed.
+func (c *VolumeQoSController) checkPVCDrift(pvc *corev1.PersistentVolumeClaim) error {
+	value, ok := pvc.Annotations[vm.QoSObservedKey]
+	if !ok || pvc.Status.Phase != corev1.ClaimBound || !pvc.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	// Skip the PVCs of the provisioners unable to read the rules back before
+	// getting the PV, the provisioner falls back to the CSI driver of the PV
+	// only if not annotated.
+	if provisioner := vm.GetPVCProvisioner(pvc); provisioner != "" {
+		if _, ok := c.volManagers[provisioner].(vm.QoSReader); !ok {
+			return nil
+		}
+	}
+	pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil {
+		return fmt.Errorf("failed to check the QoS drift of PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+	}
+	reader, ok := c.volManagers[volumeProvisioner(pvc, pv)].(vm.QoSReader)
+	if !ok {
+		return nil
+	}
+	key, err := cache.MetaNamespaceKeyFunc(pvc)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.processing.Load(key); ok {
+		// The observed rules are about to be updated.
+		return nil
+	}
+	observed, err := vm.ParseObservedQoS(value)
+	if err != nil {
+		return fmt.Errorf("failed to check the QoS drift of PVC %s: %v", key, err)
+	}
+	actual, err := reader.GetQoS(pv)
+	if err != nil {
+		return fmt.Errorf("failed to check the QoS drift of PVC %s: %v", key, err)
+	}
+
+	drift := diffRules(observed.Rules, actual.Rules)
+	if len(drift) == 0 {
+		return nil
+	}
+	klog.Warningf("QoS rules of PVC %s drifted: %v", key, drift)
+	c.drifted.Store(key, drift)
+	c.workqueue.Add(key)
+	return nil
+}
+
+// diffRules returns the rules differing between the expected and the actual
+// ones, which are the actual values, or an empty value if missing.
+func diffRules(expected, actual map[string]string) map[string]string {
+	drift := make(map[string]string)
+	for k, v := range expected {
+		if av, ok := actual[k]; !ok || av != v {
+			drift[k] = av
+		}
+	}
+	for k, v := range actual {
+		if _, ok := expected[k]; !ok {
+			drift[k] = v
+		}
+	}
+	return drift
+}
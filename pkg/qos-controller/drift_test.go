@@ -0,0 +1,198 @@
+package qoscontroller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fakeReader is a volume manager reading the rules back.
+type fakeReader struct {
+	fakeManager
+	rules map[string]string
+}
+
+func (m fakeReader) GetQoS(_ *corev1.PersistentVolume) (*vm.QoSResult, error) {
+	return &vm.QoSResult{Rules: m.rules}, nil
+}
+
+func Test_diffRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected map[string]string
+		actual   map[string]string
+		want     map[string]string
+	}{
+		{
+			name:     "same",
+			expected: map[string]string{"a": "1"},
+			actual:   map[string]string{"a": "1"},
+			want:     map[string]string{},
+		},
+		{
+			name:     "none",
+			expected: nil,
+			actual:   map[string]string{},
+			want:     map[string]string{},
+		},
+		{
+			name:     "removed changed and added",
+			expected: map[string]string{"a": "1", "b": "2"},
+			actual:   map[string]string{"b": "3", "c": "4"},
+			want:     map[string]string{"a": "", "b": "3", "c": "4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffRules(tt.expected, tt.actual); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeQoSController_checkPVCDrift(t *testing.T) {
+	observed := vm.NewObservedQoS(&vm.QoSResult{Rules: map[string]string{"conf_rbd_qos_iops_limit": "1000"}}).String()
+	newPVC := func(annotations map[string]string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "datavol", Namespace: "demo", Annotations: annotations},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-1"},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		}
+	}
+	tests := []struct {
+		name      string
+		pvc       *corev1.PersistentVolumeClaim
+		rules     map[string]string
+		wantDrift bool
+	}{
+		{
+			name: "not drifted",
+			pvc: newPVC(map[string]string{
+				vm.AnnStorageProvisioner: "rbd.csi.ceph.com",
+				vm.QoSObservedKey:        observed,
+			}),
+			rules: map[string]string{"conf_rbd_qos_iops_limit": "1000"},
+		},
+		{
+			name: "removed by hand",
+			pvc: newPVC(map[string]string{
+				vm.AnnStorageProvisioner: "rbd.csi.ceph.com",
+				vm.QoSObservedKey:        observed,
+			}),
+			rules:     map[string]string{},
+			wantDrift: true,
+		},
+		{
+			name:  "not observed",
+			pvc:   newPVC(map[string]string{vm.AnnStorageProvisioner: "rbd.csi.ceph.com"}),
+			rules: map[string]string{},
+		},
+		{
+			name: "unsupported provisioner",
+			pvc: newPVC(map[string]string{
+				vm.AnnStorageProvisioner: "other.csi.k8s.io",
+				vm.QoSObservedKey:        observed,
+			}),
+			rules: map[string]string{},
+		},
+		{
+			name: "unsupported provisioner without PV",
+			pvc: func() *corev1.PersistentVolumeClaim {
+				pvc := newPVC(map[string]string{
+					vm.AnnStorageProvisioner: "other.csi.k8s.io",
+					vm.QoSObservedKey:        observed,
+				})
+				pvc.Spec.VolumeName = "missing"
+				return pvc
+			}(),
+			rules: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if err := indexer.Add(&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}}); err != nil {
+				t.Fatal(err)
+			}
+			q := &fakeQueue{}
+			c := &VolumeQoSController{
+				pvLister:    corelisters.NewPersistentVolumeLister(indexer),
+				workqueue:   q,
+				volManagers: map[string]vm.VolumeManager{"rbd.csi.ceph.com": fakeReader{rules: tt.rules}},
+			}
+			if err := c.checkPVCDrift(tt.pvc); err != nil {
+				t.Fatalf("checkPVCDrift() error = %v", err)
+			}
+			_, drifted := c.drifted.Load("demo/datavol")
+			if drifted != tt.wantDrift {
+				t.Errorf("checkPVCDrift() drifted = %v, want %v", drifted, tt.wantDrift)
+			}
+			if requeued := len(q.added) > 0; requeued != tt.wantDrift {
+				t.Errorf("checkPVCDrift() requeued = %v, want %v", requeued, tt.wantDrift)
+			}
+		})
+	}
+}
+
+func TestVolumeQoSController_checkDrift(t *testing.T) {
+	observed := vm.NewObservedQoS(&vm.QoSResult{Rules: map[string]string{"conf_rbd_qos_iops_limit": "1000"}}).String()
+	pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i, provisioner := range []string{"rbd.csi.ceph.com", "rbd.csi.ceph.com", "rbd.csi.ceph.com", "other.csi.k8s.io"} {
+		pvName := fmt.Sprintf("pv-%d", i)
+		if provisioner == "rbd.csi.ceph.com" {
+			if err := pvIndexer.Add(&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: pvName}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("datavol-%d", i),
+				Namespace: "demo",
+				Annotations: map[string]string{
+					vm.AnnStorageProvisioner: provisioner,
+					vm.QoSObservedKey:        observed,
+				},
+			},
+			Spec:   corev1.PersistentVolumeClaimSpec{VolumeName: pvName},
+			Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		}
+		if err := pvcIndexer.Add(pvc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+	c := &VolumeQoSController{
+		ControllerConfig: &ControllerConfig{Workers: 2},
+		pvcLister:        corelisters.NewPersistentVolumeClaimLister(pvcIndexer),
+		pvLister:         corelisters.NewPersistentVolumeLister(pvIndexer),
+		workqueue:        q,
+		volManagers:      map[string]vm.VolumeManager{"rbd.csi.ceph.com": fakeReader{rules: map[string]string{}}},
+	}
+	c.checkDrift(make(chan struct{}))
+
+	var drifted []string
+	c.drifted.Range(func(key, _ interface{}) bool {
+		drifted = append(drifted, key.(string))
+		return true
+	})
+	sort.Strings(drifted)
+	if want := []string{"demo/datavol-0", "demo/datavol-1", "demo/datavol-2"}; !reflect.DeepEqual(drifted, want) {
+		t.Errorf("checkDrift() drifted = %v, want %v", drifted, want)
+	}
+	if got := q.Len(); got != 3 {
+		t.Errorf("checkDrift() requeued %d PVCs, want 3", got)
+	}
+}
@@ -0,0 +1,63 @@
+package qoscontroller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// CheckCacheSync returns an error if any of the informer caches has not synced.
+func (c *VolumeQoSController) CheckCacheSync() error {
+	for _, synced := range c.cacheSyncs {
+		if !synced() {
+			return fmt.Errorf("informer caches have not synced")
+		}
+	}
+	return nil
+}
+
+// CheckVolumeManagers reports the health of the backend of every volume
+// manager supporting health checks.
+func (c *VolumeQoSController) CheckVolumeManagers() error {
+	provisioners := make([]string, 0, len(c.volManagers))
+	for provisioner := range c.volManagers {
+		provisioners = append(provisioners, provisioner)
+	}
+	sort.Strings(provisioners)
+
+	var errs []error
+	for _, provisioner := range provisioners {
+		checker, ok := c.volManagers[provisioner].(vm.HealthChecker)
+		if !ok {
+			continue
+		}
+		if err := checker.Ping(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", provisioner, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// CheckWorkers returns an error if any worker has been processing a PVC for
+// longer than the stuck worker threshold, e.g. blocked by a hung backend call.
+func (c *VolumeQoSController) CheckWorkers() error {
+	if c.StuckWorkerThreshold <= 0 {
+		return nil
+	}
+	var stuck []string
+	c.processing.Range(func(key, start interface{}) bool {
+		if time.Since(start.(time.Time)) > c.StuckWorkerThreshold {
+			stuck = append(stuck, key.(string))
+		}
+		return true
+	})
+	if len(stuck) > 0 {
+		sort.Strings(stuck)
+		return fmt.Errorf("workers have been processing PVCs %v for more than %v", stuck, c.StuckWorkerThreshold)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package qoscontroller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type fakeManager struct {
+	pingErr error
+}
+
+func (fakeManager) Connect() error { return nil }
+func (fakeManager) Close()         {}
+func (fakeManager) SetQoS(_ *corev1.PersistentVolume, _ vm.QoSSettings) (*vm.QoSResult, error) {
+	return &vm.QoSResult{}, nil
+}
+func (fakeManager) Validate(_ vm.QoSSettings) error { return nil }
+func (m fakeManager) Ping() error                   { return m.pingErr }
+
+func TestVolumeQoSController_CheckVolumeManagers(t *testing.T) {
+	tests := []struct {
+		name     string
+		managers map[string]vm.VolumeManager
+		wantErr  bool
+	}{
+		{
+			name:     "healthy",
+			managers: map[string]vm.VolumeManager{"a": fakeManager{}},
+		},
+		{
+			name: "unhealthy",
+			managers: map[string]vm.VolumeManager{
+				"a": fakeManager{},
+				"b": fakeManager{pingErr: errors.New("timed out")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &VolumeQoSController{volManagers: tt.managers}
+			if err := c.CheckVolumeManagers(); (err != nil) != tt.wantErr {
+				t.Errorf("CheckVolumeManagers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVolumeQoSController_CheckWorkers(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		started   time.Duration
+		wantErr   bool
+	}{
+		{
+			name:      "processing",
+			threshold: time.Minute,
+			started:   time.Second,
+		},
+		{
+			name:      "stuck",
+			threshold: time.Minute,
+			started:   time.Hour,
+			wantErr:   true,
+		},
+		{
+			name:    "disabled",
+			started: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &VolumeQoSController{ControllerConfig: &ControllerConfig{StuckWorkerThreshold: tt.threshold}}
+			c.processing.Store("demo/datavol", time.Now().Add(-tt.started))
+			if err := c.CheckWorkers(); (err != nil) != tt.wantErr {
+				t.Errorf("CheckWorkers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
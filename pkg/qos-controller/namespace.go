@@ -0,0 +1,30 @@
+package qoscontroller
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// enqueueNamespacePVCs enqueues all the PVCs in the Namespace.
+func (c *VolumeQoSController) enqueueNamespacePVCs(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ns, ok := obj.(*corev1.Namespace)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("expected Namespace but got %#v", obj))
+		return
+	}
+	pvcs, err := c.pvcLister.PersistentVolumeClaims(ns.Name).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, pvc := range pvcs {
+		c.enqueuePVC(pvc)
+	}
+}
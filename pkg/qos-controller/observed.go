@@ -0,0 +1,35 @@
+package qoscontroller
+
+import (
+	"context"
+	"encoding/json"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// updateObservedQoS records the QoS rules applied to the volume in the
+// annotation of the PVC, if they have changed.
+func (c *VolumeQoSController) updateObservedQoS(pvc *corev1.PersistentVolumeClaim, observed *vm.ObservedQoS) error {
+	value := observed.String()
+	if pvc.Annotations[vm.QoSObservedKey] == value {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				vm.QoSObservedKey: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(context.TODO(), pvc.Name,
+		types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
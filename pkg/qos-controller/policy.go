@@ -0,0 +1,211 @@
+package qoscontroller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	qosv1alpha1 "github.com/crazytaxii/volume-qos-controller/pkg/apis/qos/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+// fromUnstructured converts an unstructured object from the dynamic informers
+// into the typed object.
+func fromUnstructured(obj interface{}, out interface{}) error {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("expected unstructured object but got %#v", obj)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), out); err != nil {
+		return fmt.Errorf("error converting %s %s: %v", u.GetKind(), klog.KObj(u), err)
+	}
+	return nil
+}
+
+// specChanged tells if the spec of the custom resource has changed by its
+// generation, which is bumped on the changes other than metadata and status.
+func specChanged(old, new interface{}) bool {
+	oldObj, ok := old.(*unstructured.Unstructured)
+	if !ok {
+		return true
+	}
+	newObj, ok := new.(*unstructured.Unstructured)
+	if !ok {
+		return true
+	}
+	return oldObj.GetGeneration() != newObj.GetGeneration()
+}
+
+// toPolicy converts an unstructured object from the informer into a VolumeQoSPolicy.
+func toPolicy(obj interface{}) (*qosv1alpha1.VolumeQoSPolicy, error) {
+	policy := &qosv1alpha1.VolumeQoSPolicy{}
+	if err := fromUnstructured(obj, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// policySelector returns the label selector of the policy, a nil selector
+// selects all PVCs.
+func policySelector(policy *qosv1alpha1.VolumeQoSPolicy) (labels.Selector, error) {
+	if policy.Spec.Selector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(policy.Spec.Selector)
+}
+
+// enqueuePolicyPVCs enqueues all the PVCs selected by the VolumeQoSPolicy.
+func (c *VolumeQoSController) enqueuePolicyPVCs(obj interface{}) {
+	policy, err := toPolicy(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	selector, err := policySelector(policy)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid selector of VolumeQoSPolicy %s/%s: %v", policy.Namespace, policy.Name, err))
+		return
+	}
+	pvcs, err := c.pvcLister.PersistentVolumeClaims(policy.Namespace).List(selector)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, pvc := range pvcs {
+		c.enqueuePVC(pvc)
+	}
+}
+
+// listPolicies lists all the VolumeQoSPolicies in the namespace.
+func (c *VolumeQoSController) listPolicies(namespace string) ([]*qosv1alpha1.VolumeQoSPolicy, error) {
+	objs, err := c.policyLister.ByNamespace(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	policies := make([]*qosv1alpha1.VolumeQoSPolicy, 0, len(objs))
+	for _, obj := range objs {
+		policy, err := toPolicy(obj)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// getPVCPolicy returns the VolumeQoSPolicy selecting the PVC. If the PVC is
+// selected by several policies, the oldest one wins.
+func (c *VolumeQoSController) getPVCPolicy(pvc *corev1.PersistentVolumeClaim) (*qosv1alpha1.VolumeQoSPolicy, error) {
+	policies, err := c.listPolicies(pvc.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].CreationTimestamp.Equal(&policies[j].CreationTimestamp) {
+			return policies[i].Name < policies[j].Name
+		}
+		return policies[i].CreationTimestamp.Before(&policies[j].CreationTimestamp)
+	})
+
+	for _, policy := range policies {
+		selector, err := policySelector(policy)
+		if err != nil {
+			klog.Warningf("Skip VolumeQoSPolicy %s/%s: invalid selector: %v", policy.Namespace, policy.Name, err)
+			continue
+		}
+		if selector.Matches(labels.Set(pvc.Labels)) {
+			return policy, nil
+		}
+	}
+	return nil, nil
+}
+
+// syncPolicyStatus reports the condition of the PVC on the policy selecting
+// it, and removes the PVC from the status of the policy previously selecting
+// it. The other policies in the namespace are left untouched, and a policy is
+// only written if its cached status differs.
+func (c *VolumeQoSController) syncPolicyStatus(namespace, name string, selected *qosv1alpha1.VolumeQoSPolicy, cond *metav1.Condition) {
+	policies, err := c.listPolicies(namespace)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	for _, policy := range policies {
+		isSelected := selected != nil && policy.UID == selected.UID
+		if !isSelected && policy.Status.ClaimStatus(name) == nil {
+			// Neither selecting the PVC nor holding its entry.
+			continue
+		}
+		mutate := func(status *qosv1alpha1.VolumeQoSPolicyStatus) {
+			status.RemoveClaimStatus(name)
+		}
+		if isSelected && cond != nil {
+			mutate = func(status *qosv1alpha1.VolumeQoSPolicyStatus) {
+				cs := status.ClaimStatus(name)
+				if cs == nil {
+					status.Claims = append(status.Claims, qosv1alpha1.ClaimStatus{Name: name})
+					cs = &status.Claims[len(status.Claims)-1]
+				}
+				meta.SetStatusCondition(&cs.Conditions, *cond)
+			}
+		}
+
+		// Check the cached policy first to avoid unnecessary API requests.
+		status := policy.Status.DeepCopy()
+		mutate(status)
+		if equality.Semantic.DeepEqual(status, &policy.Status) {
+			continue
+		}
+		if err := c.updatePolicyStatus(policy.Namespace, policy.Name, mutate); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error updating status of VolumeQoSPolicy %s/%s: %v", policy.Namespace, policy.Name, err))
+		}
+	}
+}
+
+// updatePolicyStatus updates the status of the policy with the mutate function,
+// retrying on conflicts.
+func (c *VolumeQoSController) updatePolicyStatus(namespace, name string, mutate func(*qosv1alpha1.VolumeQoSPolicyStatus)) error {
+	if c.DryRun {
+		klog.V(4).Infof("[dry-run] would update status of VolumeQoSPolicy %s/%s", namespace, name)
+		return nil
+	}
+	client := c.dynamicClient.Resource(qosv1alpha1.VolumeQoSPolicyResource).Namespace(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := client.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		policy, err := toPolicy(u)
+		if err != nil {
+			return err
+		}
+		status := policy.Status.DeepCopy()
+		mutate(&policy.Status)
+		policy.Status.ObservedGeneration = policy.Generation
+		if equality.Semantic.DeepEqual(status, &policy.Status) {
+			return nil
+		}
+
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+		if err != nil {
+			return err
+		}
+		_, err = client.UpdateStatus(context.TODO(), &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+		return err
+	})
+}
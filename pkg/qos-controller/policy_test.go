@@ -0,0 +1,289 @@
+package qoscontroller
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	qosv1alpha1 "github.com/crazytaxii/volume-qos-controller/pkg/apis/qos/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newTestPolicy returns a VolumeQoSPolicy in the demo namespace, holding the
+// entries of the PVCs in its status.
+func newTestPolicy(name string, created time.Time, selector *metav1.LabelSelector, claims ...string) *qosv1alpha1.VolumeQoSPolicy {
+	policy := &qosv1alpha1.VolumeQoSPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: qosv1alpha1.SchemeGroupVersion.String(), Kind: "VolumeQoSPolicy"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "demo",
+			UID:               types.UID(name + "-uid"),
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: qosv1alpha1.VolumeQoSPolicySpec{Selector: selector},
+	}
+	for _, claim := range claims {
+		policy.Status.Claims = append(policy.Status.Claims, qosv1alpha1.ClaimStatus{Name: claim})
+	}
+	return policy
+}
+
+// newPolicyController returns a controller whose policy cache and dynamic
+// client hold the policies.
+func newPolicyController(t *testing.T, policies ...*qosv1alpha1.VolumeQoSPolicy) (*VolumeQoSController, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	var objects []runtime.Object
+	for _, policy := range policies {
+		u := toUnstructured(t, policy)
+		if err := indexer.Add(u); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, u.DeepCopy())
+	}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+	return &VolumeQoSController{
+		dynamicClient:    client,
+		policyLister:     cache.NewGenericLister(indexer, qosv1alpha1.VolumeQoSPolicyResource.GroupResource()),
+		ControllerConfig: &ControllerConfig{},
+	}, client
+}
+
+func Test_specChanged(t *testing.T) {
+	newObj := func(generation int64, resourceVersion string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetGeneration(generation)
+		u.SetResourceVersion(resourceVersion)
+		return u
+	}
+	tests := []struct {
+		name string
+		old  interface{}
+		new  interface{}
+		want bool
+	}{
+		{
+			name: "resync",
+			old:  newObj(1, "100"),
+			new:  newObj(1, "100"),
+		},
+		{
+			name: "status updated",
+			old:  newObj(1, "100"),
+			new:  newObj(1, "101"),
+		},
+		{
+			name: "spec updated",
+			old:  newObj(1, "100"),
+			new:  newObj(2, "101"),
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := specChanged(tt.old, tt.new); got != tt.want {
+				t.Errorf("specChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeQoSController_getPVCPolicy(t *testing.T) {
+	now := time.Now()
+	dbSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+	invalidSelector := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+		Key: "app", Operator: "Invalid",
+	}}}
+	tests := []struct {
+		name     string
+		policies []*qosv1alpha1.VolumeQoSPolicy
+		labels   map[string]string
+		want     string
+	}{
+		{
+			name: "oldest wins",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("newer", now, nil),
+				newTestPolicy("older", now.Add(-time.Hour), dbSelector),
+			},
+			labels: map[string]string{"app": "db"},
+			want:   "older",
+		},
+		{
+			name: "not selected by the oldest",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("newer", now, nil),
+				newTestPolicy("older", now.Add(-time.Hour), dbSelector),
+			},
+			labels: map[string]string{"app": "web"},
+			want:   "newer",
+		},
+		{
+			name: "same age",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("b", now, nil),
+				newTestPolicy("a", now, nil),
+			},
+			want: "a",
+		},
+		{
+			name: "invalid selector",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("invalid", now.Add(-time.Hour), invalidSelector),
+				newTestPolicy("valid", now, nil),
+			},
+			want: "valid",
+		},
+		{
+			name: "not selected",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("db", now, dbSelector),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newPolicyController(t, tt.policies...)
+			pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "datavol", Namespace: "demo", Labels: tt.labels}}
+			policy, err := c.getPVCPolicy(pvc)
+			if err != nil {
+				t.Fatalf("getPVCPolicy() error = %v", err)
+			}
+			var got string
+			if policy != nil {
+				got = policy.Name
+			}
+			if got != tt.want {
+				t.Errorf("getPVCPolicy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeQoSController_syncPolicyStatus(t *testing.T) {
+	now := time.Now()
+	applied := appliedCondition(metav1.ConditionTrue, qosv1alpha1.ReasonApplied, "QoS settings have been applied")
+	failed := appliedCondition(metav1.ConditionFalse, qosv1alpha1.ReasonInvalidQoS, "invalid")
+	tests := []struct {
+		name     string
+		policies []*qosv1alpha1.VolumeQoSPolicy
+		selected string
+		cond     *metav1.Condition
+		dryRun   bool
+		// want are the conditions of the PVC in the status of the policies,
+		// nil if the PVC is missing, and an empty one if only the entry is
+		// expected.
+		want map[string]*metav1.Condition
+		// wantUpdated are the policies whose status is updated.
+		wantUpdated []string
+	}{
+		{
+			name: "applied",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("selected", now, nil),
+				newTestPolicy("other", now, nil, "other-vol"),
+			},
+			selected:    "selected",
+			cond:        applied,
+			want:        map[string]*metav1.Condition{"selected": applied, "other": nil},
+			wantUpdated: []string{"selected"},
+		},
+		{
+			name: "failed",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("selected", now, nil),
+			},
+			selected:    "selected",
+			cond:        failed,
+			want:        map[string]*metav1.Condition{"selected": failed},
+			wantUpdated: []string{"selected"},
+		},
+		{
+			name: "moved",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("selected", now, nil),
+				newTestPolicy("previous", now, nil, "datavol"),
+				newTestPolicy("other", now, nil),
+			},
+			selected:    "selected",
+			cond:        applied,
+			want:        map[string]*metav1.Condition{"selected": applied, "previous": nil, "other": nil},
+			wantUpdated: []string{"previous", "selected"},
+		},
+		{
+			name: "PVC deleted",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("previous", now, nil, "datavol", "other-vol"),
+				newTestPolicy("other", now, nil, "other-vol"),
+			},
+			want:        map[string]*metav1.Condition{"previous": nil, "other": nil},
+			wantUpdated: []string{"previous"},
+		},
+		{
+			name: "dry run",
+			policies: []*qosv1alpha1.VolumeQoSPolicy{
+				newTestPolicy("selected", now, nil),
+				newTestPolicy("previous", now, nil, "datavol"),
+			},
+			selected: "selected",
+			cond:     applied,
+			dryRun:   true,
+			want:     map[string]*metav1.Condition{"selected": nil, "previous": {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, client := newPolicyController(t, tt.policies...)
+			c.DryRun = tt.dryRun
+			var selected *qosv1alpha1.VolumeQoSPolicy
+			for _, policy := range tt.policies {
+				if policy.Name == tt.selected {
+					selected = policy
+				}
+			}
+			c.syncPolicyStatus("demo", "datavol", selected, tt.cond)
+
+			var updated []string
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "update" && action.GetSubresource() == "status" {
+					updated = append(updated, action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured).GetName())
+				}
+			}
+			sort.Strings(updated)
+			if !reflect.DeepEqual(updated, tt.wantUpdated) {
+				t.Errorf("syncPolicyStatus() updated %v, want %v", updated, tt.wantUpdated)
+			}
+			for name, want := range tt.want {
+				u, err := client.Resource(qosv1alpha1.VolumeQoSPolicyResource).Namespace("demo").Get(context.TODO(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				policy, err := toPolicy(u)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cs := policy.Status.ClaimStatus("datavol")
+				switch {
+				case want == nil && cs != nil:
+					t.Errorf("syncPolicyStatus() kept PVC in the status of %s", name)
+				case want != nil && cs == nil:
+					t.Errorf("syncPolicyStatus() missing PVC in the status of %s", name)
+				case want != nil && want.Type != "":
+					if len(cs.Conditions) != 1 || cs.Conditions[0].Status != want.Status || cs.Conditions[0].Reason != want.Reason {
+						t.Errorf("syncPolicyStatus() conditions of %s = %v, want %v", name, cs.Conditions, *want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package qoscontroller
+
+import (
+	"fmt"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// volumeProvisioner returns the storage provisioner of the PVC, which is the
+// CSI driver of the PV if the PVC has no provisioner annotation, e.g. bound to
+// a statically provisioned PV.
+func volumeProvisioner(pvc *corev1.PersistentVolumeClaim, pv *corev1.PersistentVolume) string {
+	if provisioner := vm.GetPVCProvisioner(pvc); provisioner != "" {
+		return provisioner
+	}
+	if pv != nil && pv.Spec.CSI != nil {
+		return pv.Spec.CSI.Driver
+	}
+	return ""
+}
+
+// enqueuePV enqueues the PVC bound to the PV.
+func (c *VolumeQoSController) enqueuePV(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pv, ok := obj.(*corev1.PersistentVolume)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("expected PV but got %#v", obj))
+		return
+	}
+	if ref := pv.Spec.ClaimRef; ref != nil {
+		c.workqueue.Add(ref.Namespace + "/" + ref.Name)
+	}
+}
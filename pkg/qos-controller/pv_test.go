@@ -0,0 +1,97 @@
+package qoscontroller
+
+import (
+	"reflect"
+	"testing"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_volumeProvisioner(t *testing.T) {
+	csiPV := &corev1.PersistentVolume{
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: "rbd.csi.ceph.com"},
+			},
+		},
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		pv          *corev1.PersistentVolume
+		want        string
+	}{
+		{
+			name:        "annotation",
+			annotations: map[string]string{vm.AnnStorageProvisioner: "a"},
+			pv:          csiPV,
+			want:        "a",
+		},
+		{
+			name:        "beta annotation",
+			annotations: map[string]string{vm.AnnBetaStorageProvisioner: "b"},
+			pv:          csiPV,
+			want:        "b",
+		},
+		{
+			name: "statically provisioned",
+			pv:   csiPV,
+			want: "rbd.csi.ceph.com",
+		},
+		{
+			name: "not CSI",
+			pv:   &corev1.PersistentVolume{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			if got := volumeProvisioner(pvc, tt.pv); got != tt.want {
+				t.Errorf("volumeProvisioner() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeQoSController_enqueuePV(t *testing.T) {
+	bound := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Namespace: "demo", Name: "datavol"},
+		},
+	}
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []interface{}
+	}{
+		{
+			name: "bound",
+			obj:  bound,
+			want: []interface{}{"demo/datavol"},
+		},
+		{
+			name: "tombstone",
+			obj:  cache.DeletedFinalStateUnknown{Key: "pv-1", Obj: bound},
+			want: []interface{}{"demo/datavol"},
+		},
+		{
+			name: "available",
+			obj:  &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &fakeQueue{}
+			c := &VolumeQoSController{workqueue: q}
+			c.enqueuePV(tt.obj)
+			if !reflect.DeepEqual(q.added, tt.want) {
+				t.Errorf("enqueuePV() added %v, want %v", q.added, tt.want)
+			}
+		})
+	}
+}
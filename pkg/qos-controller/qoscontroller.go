@@ -3,34 +3,52 @@ package qoscontroller
 import (
 	"fmt"
 	goruntime "runtime"
+	"sync"
 	"time"
 
+	qosv1alpha1 "github.com/crazytaxii/volume-qos-controller/pkg/apis/qos/v1alpha1"
+	"github.com/crazytaxii/volume-qos-controller/pkg/metrics"
 	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
 	"github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager/ceph"
 
 	"github.com/spf13/pflag"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeinformers "k8s.io/client-go/informers"
 	coreinformers "k8s.io/client-go/informers/core/v1"
+	storageinformers "k8s.io/client-go/informers/storage/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 )
 
 const (
-	DefaultResyncPeriod = 30 * time.Minute
+	DefaultResyncPeriod           = 30 * time.Minute
+	DefaultMetricsBindAddress     = ":9090"
+	DefaultHealthProbeBindAddress = ":9091"
+	DefaultStuckWorkerThreshold   = 10 * time.Minute
+	DefaultDriftCheckPeriod       = 10 * time.Minute
 
 	controllerAgentName = "volume-qos-controller"
 
-	AnnStorageProvisioner = "volume.kubernetes.io/storage-provisioner"
+	// Deprecated: use vm.AnnStorageProvisioner.
+	AnnStorageProvisioner = vm.AnnStorageProvisioner
+	// Deprecated: use vm.AnnBetaStorageProvisioner.
+	AnnBetaStorageProvisioner = vm.AnnBetaStorageProvisioner
 )
 
 type (
@@ -38,9 +56,30 @@ type (
 		ResyncPeriod time.Duration          `json:"resync_period,omitempty" yaml:"resyncPeriod,omitempty"`
 		Workers      int                    `json:"workers,omitempty" yaml:"workers,omitempty"` // the number of threadiness
 		CephRBD      *ceph.RBDManagerConfig `json:"ceph_rbd" yaml:"cephRBD"`
+		// MetricsBindAddress is the address the metrics endpoint listens on, "0" disables it.
+		MetricsBindAddress string `json:"metrics_bind_address,omitempty" yaml:"metricsBindAddress,omitempty"`
+		// HealthProbeBindAddress is the address the /healthz and /readyz endpoints listen on, "0" disables it.
+		HealthProbeBindAddress string `json:"health_probe_bind_address,omitempty" yaml:"healthProbeBindAddress,omitempty"`
+		// StuckWorkerThreshold is how long a worker may process a PVC before
+		// the liveness check fails, 0 disables the check.
+		StuckWorkerThreshold time.Duration `json:"stuck_worker_threshold,omitempty" yaml:"stuckWorkerThreshold,omitempty"`
+		// DriftCheckPeriod is the interval of comparing the QoS rules in the
+		// backend with the applied ones, 0 disables the check.
+		DriftCheckPeriod time.Duration `json:"drift_check_period,omitempty" yaml:"driftCheckPeriod,omitempty"`
+		// CleanupMode specifies which volumes have their QoS rules removed on
+		// the deletion of their PVCs, which is never, retained or always.
+		CleanupMode string `json:"cleanup_mode,omitempty" yaml:"cleanupMode,omitempty"`
+		// DryRun computes the QoS rules to be set and removed without writing
+		// them to the backends or Kubernetes, the planned changes are logged
+		// and emitted as events instead.
+		DryRun bool `json:"dry_run,omitempty" yaml:"dryRun,omitempty"`
 	}
 	VolumeQoSController struct {
-		kubeInformerFactory kubeinformers.SharedInformerFactory
+		kubeClient    kubernetes.Interface
+		dynamicClient dynamic.Interface
+
+		kubeInformerFactory    kubeinformers.SharedInformerFactory
+		dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory
 
 		pvcInformer coreinformers.PersistentVolumeClaimInformer
 		pvcLister   corelisters.PersistentVolumeClaimLister
@@ -48,7 +87,27 @@ type (
 		pvInformer coreinformers.PersistentVolumeInformer
 		pvLister   corelisters.PersistentVolumeLister
 
+		nsInformer coreinformers.NamespaceInformer
+		nsLister   corelisters.NamespaceLister
+
+		scInformer storageinformers.StorageClassInformer
+		scLister   storagelisters.StorageClassLister
+
+		policyInformer cache.SharedIndexInformer
+		policyLister   cache.GenericLister
+
+		classInformer cache.SharedIndexInformer
+		classLister   cache.GenericLister
+
+		cacheSyncs []cache.InformerSynced
+
 		workqueue workqueue.RateLimitingInterface
+		// clock tells the time of the QoS schedules.
+		clock clock.Clock
+		// processing records when the workers started processing the keys.
+		processing sync.Map
+		// drifted records the keys whose QoS rules drifted in the backend.
+		drifted sync.Map
 
 		// recorder is an event recorder for recording Event resources to the Kubernetes API.
 		recorder record.EventRecorder
@@ -64,15 +123,24 @@ func DefaultControllerConfig() *ControllerConfig {
 		ResyncPeriod: DefaultResyncPeriod,
 		Workers:      goruntime.NumCPU() / 2,
 		CephRBD:      ceph.DefaultCephRBDConfig(),
+
+		MetricsBindAddress:     DefaultMetricsBindAddress,
+		HealthProbeBindAddress: DefaultHealthProbeBindAddress,
+		StuckWorkerThreshold:   DefaultStuckWorkerThreshold,
+		DriftCheckPeriod:       DefaultDriftCheckPeriod,
+		CleanupMode:            CleanupModeRetained,
 	}
 }
 
-func (cc *ControllerConfig) InitVolumeManagers() (managers map[string]vm.VolumeManager, err error) {
+// InitVolumeManagers creates the configured volume managers, the StorageClass
+// lister is shared with them and must be synced before processing any PV.
+func (cc *ControllerConfig) InitVolumeManagers(kubeClient kubernetes.Interface, scLister storagelisters.StorageClassLister) (managers map[string]vm.VolumeManager, err error) {
 	managers = make(map[string]vm.VolumeManager)
 
 	// Ceph RBD
 	if rbd := cc.CephRBD; rbd != nil && rbd.HasProvisioner() {
-		if managers[rbd.Provisioner], err = ceph.NewCephRBDManager(rbd); err != nil {
+		rbd.DryRun = cc.DryRun
+		if managers[rbd.Provisioner], err = ceph.NewCephRBDManager(rbd, kubeClient, scLister); err != nil {
 			return nil, fmt.Errorf("error initing a Ceph RBD volume manager: %v", err)
 		}
 	}
@@ -82,9 +150,15 @@ func (cc *ControllerConfig) InitVolumeManagers() (managers map[string]vm.VolumeM
 func (cc *ControllerConfig) AddControllerConfigFlags(fs *pflag.FlagSet) {
 	fs.DurationVarP(&cc.ResyncPeriod, "resync-period", "", cc.ResyncPeriod, "the resync interval duration for the QoS controller")
 	fs.IntVarP(&cc.Workers, "workers", "", cc.Workers, "the number of threadiness")
+	fs.StringVarP(&cc.MetricsBindAddress, "metrics-bind-address", "", cc.MetricsBindAddress, "the address the metrics endpoint listens on, \"0\" disables it")
+	fs.StringVarP(&cc.HealthProbeBindAddress, "health-probe-bind-address", "", cc.HealthProbeBindAddress, "the address the health probe endpoints listen on, \"0\" disables them")
+	fs.DurationVarP(&cc.StuckWorkerThreshold, "stuck-worker-threshold", "", cc.StuckWorkerThreshold, "how long a worker may process a PVC before the liveness check fails, 0 disables the check")
+	fs.DurationVarP(&cc.DriftCheckPeriod, "drift-check-period", "", cc.DriftCheckPeriod, "the interval of checking the QoS rules drifted in the backend, 0 disables the check")
+	fs.BoolVarP(&cc.DryRun, "dry-run", "", cc.DryRun, "compute the QoS rules to be set and removed without writing them to the backends or Kubernetes, the planned changes are logged and emitted as events")
+	fs.StringVarP(&cc.CleanupMode, "cleanup-mode", "", cc.CleanupMode, "which volumes have their QoS rules removed on the deletion of their PVCs: never, retained (the PV reclaim policy is not Delete) or always")
 }
 
-func NewQosController(kubeClient kubernetes.Interface, cfg *ControllerConfig) (*VolumeQoSController, error) {
+func NewQosController(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, cfg *ControllerConfig) (*VolumeQoSController, error) {
 	// create event broadcaster
 	utilruntime.Must(scheme.AddToScheme(scheme.Scheme))
 	eventBroadcaster := record.NewBroadcaster()
@@ -94,25 +168,64 @@ func NewQosController(kubeClient kubernetes.Interface, cfg *ControllerConfig) (*
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, cfg.ResyncPeriod)
 	pvcInformer := kubeInformerFactory.Core().V1().PersistentVolumeClaims()
 	pvInformer := kubeInformerFactory.Core().V1().PersistentVolumes()
+	nsInformer := kubeInformerFactory.Core().V1().Namespaces()
+	scInformer := kubeInformerFactory.Storage().V1().StorageClasses()
+
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, cfg.ResyncPeriod)
+	policyInformer := dynamicInformerFactory.ForResource(qosv1alpha1.VolumeQoSPolicyResource)
+	classInformer := dynamicInformerFactory.ForResource(qosv1alpha1.VolumeQoSClassResource)
 
 	c := &VolumeQoSController{
-		kubeInformerFactory: kubeInformerFactory,
-		pvcInformer:         pvcInformer,
-		pvcLister:           pvcInformer.Lister(),
-		pvInformer:          pvInformer,
-		pvLister:            pvInformer.Lister(),
-		workqueue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "VolumeQoS"),
-		recorder:            recorder,
-		ControllerConfig:    cfg,
+		kubeClient:             kubeClient,
+		dynamicClient:          dynamicClient,
+		kubeInformerFactory:    kubeInformerFactory,
+		dynamicInformerFactory: dynamicInformerFactory,
+		pvcInformer:            pvcInformer,
+		pvcLister:              pvcInformer.Lister(),
+		pvInformer:             pvInformer,
+		pvLister:               pvInformer.Lister(),
+		nsInformer:             nsInformer,
+		nsLister:               nsInformer.Lister(),
+		scInformer:             scInformer,
+		scLister:               scInformer.Lister(),
+		policyInformer:         policyInformer.Informer(),
+		policyLister:           policyInformer.Lister(),
+		classInformer:          classInformer.Informer(),
+		classLister:            classInformer.Lister(),
+		workqueue:              workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "VolumeQoS"),
+		recorder:               recorder,
+		clock:                  clock.RealClock{},
+		ControllerConfig:       cfg,
+	}
+	c.cacheSyncs = []cache.InformerSynced{
+		c.pvcInformer.Informer().HasSynced,
+		c.pvInformer.Informer().HasSynced,
+		c.nsInformer.Informer().HasSynced,
+		c.scInformer.Informer().HasSynced,
+		c.policyInformer.HasSynced,
+		c.classInformer.HasSynced,
+	}
+
+	switch cfg.CleanupMode {
+	case CleanupModeNever, CleanupModeRetained, CleanupModeAlways:
+	default:
+		return nil, fmt.Errorf("invalid cleanup mode %q", cfg.CleanupMode)
 	}
 
 	// init volume managers
 	var err error
-	c.volManagers, err = cfg.InitVolumeManagers()
+	c.volManagers, err = cfg.InitVolumeManagers(kubeClient, c.scLister)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := pvcInformer.Informer().AddIndexers(cache.Indexers{
+		qosClassIndex:     qosClassIndexFunc,
+		storageClassIndex: storageClassIndexFunc,
+	}); err != nil {
+		return nil, err
+	}
+
 	klog.V(4).Infof("Setting up event handlers")
 	pvcInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: c.enqueuePVC,
@@ -128,6 +241,64 @@ func NewQosController(kubeClient kubernetes.Interface, cfg *ControllerConfig) (*
 		},
 		DeleteFunc: c.enqueuePVC,
 	})
+	// Requeue the PVC bound to the PV, e.g. the PV has been expanded.
+	pvInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueuePV,
+		UpdateFunc: func(old, new interface{}) {
+			oldPV := old.(*corev1.PersistentVolume)
+			newPV := new.(*corev1.PersistentVolume)
+			if oldPV.ResourceVersion == newPV.ResourceVersion {
+				return
+			}
+			c.enqueuePV(new)
+		},
+	})
+	// Requeue the PVCs selected by the policy before and after the change.
+	c.policyInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueuePolicyPVCs,
+		UpdateFunc: func(old, new interface{}) {
+			// Skip the resyncs and the status updates written by the
+			// controller itself, which leave the generation unchanged.
+			if !specChanged(old, new) {
+				return
+			}
+			c.enqueuePolicyPVCs(old)
+			c.enqueuePolicyPVCs(new)
+		},
+		DeleteFunc: c.enqueuePolicyPVCs,
+	})
+	c.classInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueClassPVCs,
+		UpdateFunc: func(old, new interface{}) {
+			if !specChanged(old, new) {
+				return
+			}
+			c.enqueueClassPVCs(new)
+		},
+		DeleteFunc: c.enqueueClassPVCs,
+	})
+	scInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueStorageClassPVCs,
+		UpdateFunc: func(old, new interface{}) {
+			oldSC := old.(*storagev1.StorageClass)
+			newSC := new.(*storagev1.StorageClass)
+			if oldSC.ResourceVersion == newSC.ResourceVersion {
+				return
+			}
+			c.enqueueStorageClassPVCs(new)
+		},
+		DeleteFunc: c.enqueueStorageClassPVCs,
+	})
+	nsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			oldNS := old.(*corev1.Namespace)
+			newNS := new.(*corev1.Namespace)
+			if oldNS.ResourceVersion == newNS.ResourceVersion {
+				return
+			}
+			c.enqueueNamespacePVCs(new)
+		},
+	})
 
 	return c, nil
 }
@@ -146,13 +317,21 @@ func (c *VolumeQoSController) Run(stopCh <-chan struct{}) error {
 	}
 
 	c.kubeInformerFactory.Start(stopCh)
+	c.dynamicInformerFactory.Start(stopCh)
 
 	klog.Info("Waiting for informer caches to sync")
-	if !cache.WaitForCacheSync(stopCh, c.pvcInformer.Informer().HasSynced,
-		c.pvInformer.Informer().HasSynced) {
+	if !cache.WaitForCacheSync(stopCh, c.cacheSyncs...) {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
+	// Reconcile the backend-wide QoS settings on each resync.
+	if c.ResyncPeriod > 0 {
+		go wait.Until(c.reconcileVolumeManagers, c.ResyncPeriod, stopCh)
+	}
+	if c.DriftCheckPeriod > 0 {
+		go wait.Until(func() { c.checkDrift(stopCh) }, c.DriftCheckPeriod, stopCh)
+	}
+
 	klog.Infof("Starting %d workers", c.Workers)
 	for i := 0; i < c.Workers; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
@@ -169,6 +348,20 @@ func (c *VolumeQoSController) Run(stopCh <-chan struct{}) error {
 	return nil
 }
 
+// reconcileVolumeManagers reconciles the volume managers which have
+// backend-wide QoS settings.
+func (c *VolumeQoSController) reconcileVolumeManagers() {
+	for provisioner, manager := range c.volManagers {
+		reconciler, ok := manager.(vm.Reconciler)
+		if !ok {
+			continue
+		}
+		if err := reconciler.Reconcile(); err != nil {
+			utilruntime.HandleError(fmt.Errorf("error reconciling volume manager of %s: %v", provisioner, err))
+		}
+	}
+}
+
 // runWorker is a long-running function that will continually call the
 // processNextWorkItem function in order to read and process a message on the
 // workqueue.
@@ -208,6 +401,9 @@ func (c *VolumeQoSController) processNextWorkItem() bool {
 			utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 			return nil
 		}
+		// Record the processing key for the liveness check.
+		c.processing.Store(key, time.Now())
+		defer c.processing.Delete(key)
 		// Run the syncHandler, passing it the namespace/name string of the PVC to be synced.
 		if err := c.syncHandler(key); err != nil {
 			// Put the item back on the workqueue to handle any transient errors.
@@ -241,6 +437,11 @@ func (c *VolumeQoSController) enqueuePVC(obj interface{}) {
 // syncHandler compares the actual state with the desired, and attempts to
 // converge the two.
 func (c *VolumeQoSController) syncHandler(key string) (err error) {
+	result := metrics.ResultError
+	defer func() {
+		metrics.ReconcileTotal.WithLabelValues(result).Inc()
+	}()
+
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -253,6 +454,10 @@ func (c *VolumeQoSController) syncHandler(key string) (err error) {
 		// The PVC resource may no longer exist, in which case we stop processing.
 		if errors.IsNotFound(err) {
 			utilruntime.HandleError(fmt.Errorf("pvc '%s' in work queue no longer exists", key))
+			c.syncPolicyStatus(namespace, name, nil, nil)
+			c.drifted.Delete(key)
+			metrics.UntrackVolume(key)
+			result = metrics.ResultNotFound
 			return nil
 		}
 
@@ -261,47 +466,190 @@ func (c *VolumeQoSController) syncHandler(key string) (err error) {
 
 	klog.V(4).Infof("Processing PVC %s", key)
 
+	if !pvc.DeletionTimestamp.IsZero() && hasCleanupFinalizer(pvc) {
+		result = metrics.ResultCleanedUp
+		if err = c.cleanup(key, pvc); err != nil {
+			result = metrics.ResultError
+		}
+		return
+	}
 	if pvc.Status.Phase != corev1.ClaimBound || !pvc.DeletionTimestamp.IsZero() {
 		// It's unnecessary to process PVCs that are unbound or being deleted.
+		result = metrics.ResultUnbound
 		return
 	}
 
-	provisioner, ok := pvc.Annotations[AnnStorageProvisioner]
-	if !ok {
+	// Get the PV bound to the PVC.
+	pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil {
+		return err
+	}
+
+	provisioner := volumeProvisioner(pvc, pv)
+	if provisioner == "" {
 		klog.Warningf("Skip processing PVC %s: missing storage provisioner annotation", key)
+		result = metrics.ResultMissingAnnotation
 		return
 	}
 	manager, ok := c.volManagers[provisioner]
 	if !ok {
 		klog.Warningf("Skip processing PVC %s: CSI driver %s is not supported", key, provisioner)
+		result = metrics.ResultUnsupportedProvisioner
 		return
 	}
 
-	// Get the PV bound to the PVC.
-	pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+	klog.V(4).Infof("Processing PV %s bound to PVC %s", pv.Name, key)
+
+	// Get the QoS settings from the StorageClass of the PVC, the defaults of
+	// the Namespace, the policy selecting the PVC, the class referenced by the
+	// PVC and the annotations of the PV, which are overridden by the
+	// annotations of the PVC.
+	scSettings, err := c.getStorageClassQoSSettings(pvc)
 	if err != nil {
 		return err
 	}
-
-	klog.V(4).Infof("Processing PV %s bound to PVC %s", pv.Name, key)
-
-	// Get the QoS settings from annotations of the PVC.
-	qosSettings := vm.GetPVCQoSSettings(pvc)
-	// Validate the value of QoS settings.
-	if err := manager.Validate(qosSettings); err != nil {
+	ns, err := c.nsLister.Get(namespace)
+	if err != nil {
+		return err
+	}
+	policy, err := c.getPVCPolicy(pvc)
+	if err != nil {
+		return err
+	}
+	var policySettings, classSettings vm.QoSSettings
+	if policy != nil {
+		klog.V(4).Infof("PVC %s is selected by VolumeQoSPolicy %s", key, policy.Name)
+		policySettings = policy.Spec.Settings()
+	}
+	if className := pvc.Annotations[vm.QoSClassKey]; className != "" {
+		class, err := c.getClass(className)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				// The PVC will be requeued once the class is created.
+				klog.Warningf("Skip processing PVC %s: VolumeQoSClass %s not found", key, className)
+				c.recorder.Eventf(pvc, corev1.EventTypeWarning, "QoSClassNotFound", "VolumeQoSClass %s not found", className)
+				result = metrics.ResultInvalid
+				return nil
+			}
+			return err
+		}
+		classSettings = class.Spec.Settings()
+	}
+	// The settings of the active schedules override the ones of the policy
+	// and the PVC, then the size-proportional settings are converted into the
+	// limits with the capacity of the volume. At last, validate the value of
+	// QoS settings and the relations between them.
+	var (
+		qosSettings vm.QoSSettings
+		warnings    []string
+	)
+	policyScheduled, pvcScheduled, err := c.getScheduledQoSSettings(key, pvc, policy)
+	if err == nil {
+		qosSettings = vm.MergeQoSLayers(scSettings, vm.GetNamespaceQoSDefaults(ns), policySettings, policyScheduled,
+			classSettings, vm.GetPVQoSSettings(pv), vm.GetPVCQoSSettings(pvc), pvcScheduled)
+		qosSettings, err = vm.ResolveQoSPerGiB(qosSettings, pv.Spec.Capacity[corev1.ResourceStorage])
+	}
+	if err == nil {
+		warnings, err = vm.ValidateQoSSettings(manager, qosSettings)
+	}
+	if err != nil {
 		klog.Warningf("Failed to validate the QoS setting of PVC %s: %v", key, err)
 		c.recorder.Event(pvc, corev1.EventTypeWarning, "InvalidQoSAnnotation", err.Error())
+		c.syncPolicyStatus(namespace, name, policy, appliedCondition(metav1.ConditionFalse, qosv1alpha1.ReasonInvalidQoS, err.Error()))
+		result = metrics.ResultInvalid
 		return nil
 	}
+	for _, warning := range warnings {
+		c.recorder.Event(pvc, corev1.EventTypeWarning, "QoSSettingsConflict", warning)
+	}
 
-	if err = manager.SetQoS(pv, qosSettings); err != nil {
+	// Enforce the maximum QoS settings of the Namespace.
+	clamped, exceeded := vm.ClampQoSSettings(qosSettings, vm.GetNamespaceQoSMax(ns))
+	if len(exceeded) > 0 {
+		if vm.GetNamespaceQoSMaxPolicy(ns) == vm.QoSMaxPolicyReject {
+			msg := fmt.Sprintf("QoS settings %v exceed the maximum of namespace %s", exceeded, namespace)
+			klog.Warningf("Skip processing PVC %s: %s", key, msg)
+			c.recorder.Event(pvc, corev1.EventTypeWarning, "QoSExceedsNamespaceMax", msg)
+			c.syncPolicyStatus(namespace, name, policy, appliedCondition(metav1.ConditionFalse, qosv1alpha1.ReasonExceedsNamespaceMax, msg))
+			result = metrics.ResultInvalid
+			return nil
+		}
+		c.recorder.Eventf(pvc, corev1.EventTypeWarning, "QoSClamped", "QoS settings %v are clamped to the maximum of namespace %s", exceeded, namespace)
+	}
+	// The clamping only lowers the exceeding settings and fills the unset
+	// ones, which may break the relations between them, e.g. a burst lowered
+	// below its limit.
+	if len(exceeded) > 0 || len(clamped) != len(qosSettings) {
+		clampWarnings, err := vm.ValidateQoSRelations(clamped)
+		if err != nil {
+			msg := fmt.Sprintf("QoS settings clamped to the maximum of namespace %s are invalid: %v", namespace, err)
+			klog.Warningf("Skip processing PVC %s: %s", key, msg)
+			c.recorder.Event(pvc, corev1.EventTypeWarning, "InvalidQoSAnnotation", msg)
+			c.syncPolicyStatus(namespace, name, policy, appliedCondition(metav1.ConditionFalse, qosv1alpha1.ReasonInvalidQoS, msg))
+			result = metrics.ResultInvalid
+			return nil
+		}
+		emitted := sets.NewString(warnings...)
+		for _, warning := range clampWarnings {
+			if !emitted.Has(warning) {
+				c.recorder.Event(pvc, corev1.EventTypeWarning, "QoSSettingsConflict", warning)
+			}
+		}
+	}
+	qosSettings = clamped
+
+	start := time.Now()
+	applied, err := manager.SetQoS(pv, qosSettings)
+	metrics.SetQoSDuration.WithLabelValues(provisioner).Observe(time.Since(start).Seconds())
+	if err != nil {
+		result = metrics.ResultBackendError
 		c.recorder.Event(pvc, corev1.EventTypeWarning, "SettingQoSFailed", err.Error())
+		c.syncPolicyStatus(namespace, name, policy, appliedCondition(metav1.ConditionFalse, qosv1alpha1.ReasonSettingQoSFailed, err.Error()))
 		if _, ok := err.(vm.ErrInvalidArgs); ok {
 			klog.Error(err.Error())
 			// invalid arguments should not be retried.
 			return nil
 		}
+		return
+	}
+	if c.DryRun {
+		result = metrics.ResultDryRun
+		if applied.Changed {
+			c.recorder.Eventf(pvc, corev1.EventTypeNormal, "QoSDryRun", "QoS rules %v would be set and %v removed on %s volume %s",
+				applied.Set, applied.Removed, applied.Backend, applied.Volume)
+		}
+		return nil
+	}
+	result = metrics.ResultApplied
+	metrics.TrackVolume(provisioner, key, len(applied.Rules) > 0)
+	if drift, ok := c.drifted.LoadAndDelete(key); ok && applied.Changed {
+		metrics.DriftCorrectedTotal.WithLabelValues(provisioner).Inc()
+		c.recorder.Eventf(pvc, corev1.EventTypeWarning, "QoSDriftCorrected", "QoS rules %v of %s volume %s drifted and have been corrected",
+			drift, applied.Backend, applied.Volume)
 	}
+	if applied.Changed {
+		c.recorder.Eventf(pvc, corev1.EventTypeNormal, "QoSApplied", "QoS rules %v applied to %s volume %s",
+			applied.Rules, applied.Backend, applied.Volume)
+	}
+	c.syncPolicyStatus(namespace, name, policy, appliedCondition(metav1.ConditionTrue, qosv1alpha1.ReasonApplied, "QoS settings have been applied"))
 
-	return
+	// Hold the PVC on deletion until the QoS rules have been removed.
+	if len(applied.Rules) > 0 && c.shouldCleanup(pv) {
+		if err := c.setCleanupFinalizer(pvc, true); err != nil {
+			return err
+		}
+	}
+
+	// Record the applied QoS rules on the PVC.
+	return c.updateObservedQoS(pvc, vm.NewObservedQoS(applied))
+}
+
+// appliedCondition returns a new Applied condition for the policy status.
+func appliedCondition(status metav1.ConditionStatus, reason, message string) *metav1.Condition {
+	return &metav1.Condition{
+		Type:    qosv1alpha1.ConditionApplied,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}
 }
@@ -0,0 +1,183 @@
+package qoscontroller
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	qosv1alpha1 "github.com/crazytaxii/volume-qos-controller/pkg/apis/qos/v1alpha1"
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestVolumeQoSController_syncHandler_merge(t *testing.T) {
+	const (
+		layerSC     = "StorageClass"
+		layerNS     = "Namespace"
+		layerPolicy = "VolumeQoSPolicy"
+		layerClass  = "VolumeQoSClass"
+		layerPV     = "PV"
+		layerPVC    = "PVC"
+	)
+	int64Ptr := func(v int64) *int64 { return &v }
+	tests := []struct {
+		name string
+		// iops are the IOPS limits set by the layers.
+		iops map[string]int64
+		// perGiB sets the IOPS per GiB of the StorageClass.
+		perGiB bool
+		want   vm.QoSSettings
+	}{
+		{
+			name: "PVC",
+			iops: map[string]int64{layerSC: 100, layerNS: 200, layerPolicy: 300, layerClass: 400, layerPV: 500, layerPVC: 600},
+			want: vm.QoSSettings{vm.QoSLimitIOPSKey: "600", vm.QoSLimitBPSKey: "10M"},
+		},
+		{
+			name: "PV",
+			iops: map[string]int64{layerSC: 100, layerNS: 200, layerPolicy: 300, layerClass: 400, layerPV: 500},
+			want: vm.QoSSettings{vm.QoSLimitIOPSKey: "500", vm.QoSLimitBPSKey: "10M"},
+		},
+		{
+			name: "VolumeQoSClass",
+			iops: map[string]int64{layerSC: 100, layerNS: 200, layerPolicy: 300, layerClass: 400},
+			want: vm.QoSSettings{vm.QoSLimitIOPSKey: "400", vm.QoSLimitBPSKey: "10M"},
+		},
+		{
+			name: "VolumeQoSPolicy",
+			iops: map[string]int64{layerSC: 100, layerNS: 200, layerPolicy: 300},
+			want: vm.QoSSettings{vm.QoSLimitIOPSKey: "300", vm.QoSLimitBPSKey: "10M"},
+		},
+		{
+			name: "Namespace",
+			iops: map[string]int64{layerSC: 100, layerNS: 200},
+			want: vm.QoSSettings{vm.QoSLimitIOPSKey: "200", vm.QoSLimitBPSKey: "10M"},
+		},
+		{
+			name: "StorageClass",
+			iops: map[string]int64{layerSC: 100},
+			want: vm.QoSSettings{vm.QoSLimitIOPSKey: "100", vm.QoSLimitBPSKey: "10M"},
+		},
+		{
+			name: "StorageClass parameters",
+			want: vm.QoSSettings{vm.QoSLimitIOPSKey: "1", vm.QoSLimitBPSKey: "10M"},
+		},
+		{
+			name:   "StorageClass per GiB",
+			perGiB: true,
+			want:   vm.QoSSettings{vm.QoSLimitIOPSKey: "30", vm.QoSLimitBPSKey: "10M"},
+		},
+		{
+			name:   "PVC over StorageClass per GiB",
+			iops:   map[string]int64{layerPVC: 600},
+			perGiB: true,
+			want:   vm.QoSSettings{vm.QoSLimitIOPSKey: "600", vm.QoSLimitBPSKey: "10M"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := func(layer string) map[string]string {
+				if v, ok := tt.iops[layer]; ok {
+					return map[string]string{vm.QoSLimitIOPSKey: fmt.Sprint(v)}
+				}
+				return map[string]string{}
+			}
+
+			sc := &storagev1.StorageClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "fast", Annotations: annotations(layerSC)},
+				// The parameters are overridden by the annotations.
+				Parameters: map[string]string{vm.QoSLimitBPSKey: "10M", vm.QoSLimitIOPSKey: "1"},
+			}
+			if tt.perGiB {
+				sc.Annotations[vm.QoSIOPSPerGiBKey] = "3"
+			}
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "demo", Annotations: annotations(layerNS)}}
+			pv := &corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv-1", Annotations: annotations(layerPV)},
+				Spec: corev1.PersistentVolumeSpec{
+					Capacity:                      corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+					PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+				},
+			}
+			pvc := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "datavol", Namespace: "demo", Annotations: annotations(layerPVC)},
+				Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-1", StorageClassName: &sc.Name},
+				Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+			}
+			pvc.Annotations[vm.AnnStorageProvisioner] = "rbd.csi.ceph.com"
+
+			scIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			nsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			policyIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			classIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for indexer, obj := range map[cache.Indexer]interface{}{
+				scIndexer:  sc,
+				nsIndexer:  ns,
+				pvIndexer:  pv,
+				pvcIndexer: pvc,
+			} {
+				if err := indexer.Add(obj); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var dynamicObjects []runtime.Object
+			if v, ok := tt.iops[layerPolicy]; ok {
+				policy := toUnstructured(t, &qosv1alpha1.VolumeQoSPolicy{
+					TypeMeta:   metav1.TypeMeta{APIVersion: qosv1alpha1.SchemeGroupVersion.String(), Kind: "VolumeQoSPolicy"},
+					ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "demo", UID: "policy-uid"},
+					Spec:       qosv1alpha1.VolumeQoSPolicySpec{QoSSpec: qosv1alpha1.QoSSpec{IOPSLimit: int64Ptr(v)}},
+				})
+				if err := policyIndexer.Add(policy); err != nil {
+					t.Fatal(err)
+				}
+				dynamicObjects = append(dynamicObjects, policy)
+			}
+			if v, ok := tt.iops[layerClass]; ok {
+				if err := classIndexer.Add(toUnstructured(t, &qosv1alpha1.VolumeQoSClass{
+					ObjectMeta: metav1.ObjectMeta{Name: "gold"},
+					Spec:       qosv1alpha1.QoSSpec{IOPSLimit: int64Ptr(v)},
+				})); err != nil {
+					t.Fatal(err)
+				}
+				pvc.Annotations[vm.QoSClassKey] = "gold"
+			}
+
+			var set []vm.QoSSettings
+			c := &VolumeQoSController{
+				kubeClient:       fake.NewSimpleClientset(pvc),
+				dynamicClient:    dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), dynamicObjects...),
+				pvcLister:        corelisters.NewPersistentVolumeClaimLister(pvcIndexer),
+				pvLister:         corelisters.NewPersistentVolumeLister(pvIndexer),
+				nsLister:         corelisters.NewNamespaceLister(nsIndexer),
+				scLister:         storagelisters.NewStorageClassLister(scIndexer),
+				policyLister:     cache.NewGenericLister(policyIndexer, qosv1alpha1.VolumeQoSPolicyResource.GroupResource()),
+				classLister:      cache.NewGenericLister(classIndexer, qosv1alpha1.VolumeQoSClassResource.GroupResource()),
+				recorder:         record.NewFakeRecorder(10),
+				volManagers:      map[string]vm.VolumeManager{"rbd.csi.ceph.com": fakeRemover{set: &set}},
+				ControllerConfig: &ControllerConfig{CleanupMode: CleanupModeRetained},
+			}
+			if err := c.syncHandler("demo/datavol"); err != nil {
+				t.Fatalf("syncHandler() error = %v", err)
+			}
+			if len(set) != 1 {
+				t.Fatalf("syncHandler() set QoS %d times, want 1", len(set))
+			}
+			if !reflect.DeepEqual(set[0], tt.want) {
+				t.Errorf("syncHandler() set %v, want %v", set[0], tt.want)
+			}
+		})
+	}
+}
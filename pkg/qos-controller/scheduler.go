@@ -0,0 +1,51 @@
+package qoscontroller
+
+import (
+	qosv1alpha1 "github.com/crazytaxii/volume-qos-controller/pkg/apis/qos/v1alpha1"
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// getScheduledQoSSettings returns the QoS settings of the active windows of
+// the schedules of the policy and the PVC, which override the settings of the
+// policy and the PVC respectively. The PVC is requeued at the next boundary of
+// the windows, so that the settings are switched in time.
+func (c *VolumeQoSController) getScheduledQoSSettings(key string, pvc *corev1.PersistentVolumeClaim, policy *qosv1alpha1.VolumeQoSPolicy) (policySettings, pvcSettings vm.QoSSettings, err error) {
+	var policySchedules []vm.QoSSchedule
+	if policy != nil {
+		policySchedules = policy.Spec.QoSSchedules()
+		for i := range policySchedules {
+			if err := policySchedules[i].Validate(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	pvcSchedules, err := vm.GetPVCQoSSchedules(pvc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(policySchedules) == 0 && len(pvcSchedules) == 0 {
+		return nil, nil, nil
+	}
+
+	now := c.clock.Now()
+	policySettings, policyNext, err := vm.ActiveQoSSettings(policySchedules, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	pvcSettings, pvcNext, err := vm.ActiveQoSSettings(pvcSchedules, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	next := policyNext
+	if next.IsZero() || (!pvcNext.IsZero() && pvcNext.Before(next)) {
+		next = pvcNext
+	}
+	if !next.IsZero() {
+		klog.V(4).Infof("Requeue PVC %s at the QoS schedule boundary %s", key, next)
+		c.workqueue.AddAfter(key, next.Sub(now))
+	}
+	return policySettings, pvcSettings, nil
+}
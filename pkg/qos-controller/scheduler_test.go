@@ -0,0 +1,114 @@
+package qoscontroller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	qosv1alpha1 "github.com/crazytaxii/volume-qos-controller/pkg/apis/qos/v1alpha1"
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// fakeQueue records the items added and the delays of the items added after
+// a while.
+type fakeQueue struct {
+	workqueue.RateLimitingInterface
+	added  []interface{}
+	delays map[interface{}]time.Duration
+}
+
+func (q *fakeQueue) Add(item interface{}) {
+	q.added = append(q.added, item)
+}
+
+func (q *fakeQueue) AddAfter(item interface{}, d time.Duration) {
+	q.delays[item] = d
+}
+
+func TestVolumeQoSController_getScheduledQoSSettings(t *testing.T) {
+	iops := int64(10000)
+	policy := &qosv1alpha1.VolumeQoSPolicy{
+		Spec: qosv1alpha1.VolumeQoSPolicySpec{
+			Schedules: []qosv1alpha1.QoSScheduleSpec{{
+				Schedule: "0 1 * * *",
+				Duration: metav1.Duration{Duration: 4 * time.Hour},
+				QoSSpec:  qosv1alpha1.QoSSpec{IOPSLimit: &iops},
+			}},
+		},
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				vm.QoSSchedulesKey: `[{"schedule":"30 4 * * *","duration":"1h","qos":{"qos-bps-limit":"1G"}}]`,
+			},
+		},
+	}
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		now        time.Time
+		wantPolicy vm.QoSSettings
+		wantPVC    vm.QoSSettings
+		wantDelay  time.Duration
+	}{
+		{
+			name:       "before the windows",
+			now:        day,
+			wantPolicy: vm.QoSSettings{},
+			wantPVC:    vm.QoSSettings{},
+			wantDelay:  time.Hour,
+		},
+		{
+			name:       "policy window",
+			now:        day.Add(2 * time.Hour),
+			wantPolicy: vm.QoSSettings{vm.QoSLimitIOPSKey: "10000"},
+			wantPVC:    vm.QoSSettings{},
+			wantDelay:  150 * time.Minute,
+		},
+		{
+			name:       "both windows",
+			now:        day.Add(4*time.Hour + 45*time.Minute),
+			wantPolicy: vm.QoSSettings{vm.QoSLimitIOPSKey: "10000"},
+			wantPVC:    vm.QoSSettings{vm.QoSLimitBPSKey: "1G"},
+			wantDelay:  15 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &fakeQueue{delays: make(map[interface{}]time.Duration)}
+			c := &VolumeQoSController{
+				workqueue: q,
+				clock:     testingclock.NewFakeClock(tt.now),
+			}
+			gotPolicy, gotPVC, err := c.getScheduledQoSSettings("demo/datavol", pvc, policy)
+			if err != nil {
+				t.Fatalf("getScheduledQoSSettings() error = %v", err)
+			}
+			if !reflect.DeepEqual(gotPolicy, tt.wantPolicy) {
+				t.Errorf("getScheduledQoSSettings() policy settings = %v, want %v", gotPolicy, tt.wantPolicy)
+			}
+			if !reflect.DeepEqual(gotPVC, tt.wantPVC) {
+				t.Errorf("getScheduledQoSSettings() PVC settings = %v, want %v", gotPVC, tt.wantPVC)
+			}
+			if got := q.delays["demo/datavol"]; got != tt.wantDelay {
+				t.Errorf("requeued after %v, want %v", got, tt.wantDelay)
+			}
+		})
+	}
+
+	t.Run("no schedules", func(t *testing.T) {
+		q := &fakeQueue{delays: make(map[interface{}]time.Duration)}
+		c := &VolumeQoSController{workqueue: q, clock: testingclock.NewFakeClock(day)}
+		if _, _, err := c.getScheduledQoSSettings("demo/datavol", &corev1.PersistentVolumeClaim{}, nil); err != nil {
+			t.Fatalf("getScheduledQoSSettings() error = %v", err)
+		}
+		if len(q.delays) != 0 {
+			t.Errorf("requeued %v, want none", q.delays)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package qoscontroller
+
+import (
+	"fmt"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// storageClassIndex indexes PVCs by the name of their StorageClass.
+const storageClassIndex = "storageClass"
+
+// storageClassIndexFunc is the index function of storageClassIndex.
+func storageClassIndexFunc(obj interface{}) ([]string, error) {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return nil, nil
+	}
+	if sc := pvc.Spec.StorageClassName; sc != nil && *sc != "" {
+		return []string{*sc}, nil
+	}
+	return nil, nil
+}
+
+// enqueueStorageClassPVCs enqueues all the PVCs of the StorageClass.
+func (c *VolumeQoSController) enqueueStorageClassPVCs(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	sc, ok := obj.(*storagev1.StorageClass)
+	if !ok {
+		utilruntime.HandleError(fmt.Errorf("expected StorageClass but got %#v", obj))
+		return
+	}
+	pvcs, err := c.pvcInformer.Informer().GetIndexer().ByIndex(storageClassIndex, sc.Name)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, pvc := range pvcs {
+		c.enqueuePVC(pvc)
+	}
+}
+
+// getStorageClassQoSSettings returns the default QoS settings from the
+// StorageClass of the PVC.
+func (c *VolumeQoSController) getStorageClassQoSSettings(pvc *corev1.PersistentVolumeClaim) (vm.QoSSettings, error) {
+	name := pvc.Spec.StorageClassName
+	if name == nil || *name == "" {
+		return nil, nil
+	}
+	sc, err := c.scLister.Get(*name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// The StorageClass of a bound PVC may have been deleted.
+			return nil, nil
+		}
+		return nil, err
+	}
+	return vm.GetStorageClassQoSSettings(sc), nil
+}
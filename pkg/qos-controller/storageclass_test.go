@@ -0,0 +1,103 @@
+package qoscontroller
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_storageClassIndexFunc(t *testing.T) {
+	fast, empty := "fast", ""
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []string
+	}{
+		{
+			name: "StorageClass",
+			obj:  &corev1.PersistentVolumeClaim{Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: &fast}},
+			want: []string{"fast"},
+		},
+		{
+			name: "empty StorageClass",
+			obj:  &corev1.PersistentVolumeClaim{Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: &empty}},
+		},
+		{
+			name: "no StorageClass",
+			obj:  &corev1.PersistentVolumeClaim{},
+		},
+		{
+			name: "not PVC",
+			obj:  &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storageClassIndexFunc(tt.obj)
+			if err != nil {
+				t.Fatalf("storageClassIndexFunc() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("storageClassIndexFunc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeQoSController_enqueueStorageClassPVCs(t *testing.T) {
+	newPVC := func(namespace, name, sc string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: &sc},
+		}
+	}
+	pvcInformer := newPVCInformer(t,
+		newPVC("demo", "fast-1", "fast"),
+		newPVC("demo", "slow-1", "slow"),
+		newPVC("other", "fast-2", "fast"),
+		newPVC("demo", "static", ""),
+	)
+	newSC := func(name string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []interface{}
+	}{
+		{
+			name: "used",
+			obj:  newSC("fast"),
+			want: []interface{}{"demo/fast-1", "other/fast-2"},
+		},
+		{
+			name: "deleted",
+			obj:  cache.DeletedFinalStateUnknown{Key: "slow", Obj: newSC("slow")},
+			want: []interface{}{"demo/slow-1"},
+		},
+		{
+			name: "unused",
+			obj:  newSC("standard"),
+		},
+		{
+			name: "not StorageClass",
+			obj:  newPVC("demo", "fast-1", "fast"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &fakeQueue{}
+			c := &VolumeQoSController{pvcInformer: pvcInformer, workqueue: q}
+			c.enqueueStorageClassPVCs(tt.obj)
+			sort.Slice(q.added, func(i, j int) bool { return q.added[i].(string) < q.added[j].(string) })
+			if !reflect.DeepEqual(q.added, tt.want) {
+				t.Errorf("enqueueStorageClassPVCs() enqueued %v, want %v", q.added, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package ceph
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crazytaxii/volume-qos-controller/pkg/metrics"
+
+	"github.com/ceph/go-ceph/rados"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const (
+	DefaultHealthCheckInterval = 30 * time.Second
+	DefaultOpTimeout           = 30 * time.Second
+
+	reconnectInitialBackoff = time.Second
+	reconnectMaxBackoff     = 2 * time.Minute
+
+	// defaultClusterLabel is the metrics label of the default cluster without ID.
+	defaultClusterLabel = "default"
+)
+
+var errNotConnected = errors.New("not connected to Ceph cluster")
+
+// radosConn is a connection to a Ceph cluster, which is a *rados.Conn.
+type radosConn interface {
+	GetClusterStats() (rados.ClusterStat, error)
+	OpenIOContext(pool string) (*rados.IOContext, error)
+	Shutdown()
+}
+
+// connDialer creates the connections to the Ceph clusters, so that the
+// supervision of the connections can be tested without librados.
+type connDialer interface {
+	Dial(monitors, user, key string, opTimeout time.Duration) (radosConn, error)
+}
+
+// radosDialer dials the Ceph clusters with librados.
+type radosDialer struct{}
+
+func (radosDialer) Dial(monitors, user, key string, opTimeout time.Duration) (radosConn, error) {
+	conn, err := newConn(monitors, user, key, opTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// cluster is a supervised connection to a Ceph cluster, which is rebuilt once
+// it goes bad or the credentials Secret changes.
+type cluster struct {
+	*ClusterConfig
+	provisioner         string
+	healthCheckInterval time.Duration
+	opTimeout           time.Duration
+	kubeClient          kubernetes.Interface
+	dialer              connDialer
+	clock               clock.WithTicker
+	// backoff is the backoff of reconnecting, which is copied on each
+	// reconnection.
+	backoff wait.Backoff
+
+	mu          sync.RWMutex
+	conn        radosConn
+	stopCh      chan struct{}
+	reconnectCh chan struct{}
+
+	// healthMu guards healthErr, the result of the last health check of the
+	// supervisor, so that it is read without calling the cluster.
+	healthMu  sync.Mutex
+	healthErr error
+	// secretVersion is the resource version of the credentials Secret last
+	// read, which is a string.
+	secretVersion atomic.Value
+	// beforeSwap, if set, is called by setConn before it waits for the
+	// in-flight operations, for tests.
+	beforeSwap func()
+}
+
+func newCluster(cfg *RBDManagerConfig, cc *ClusterConfig, kubeClient kubernetes.Interface) *cluster {
+	return &cluster{
+		ClusterConfig:       cc,
+		provisioner:         cfg.Provisioner,
+		healthCheckInterval: cfg.HealthCheckInterval,
+		opTimeout:           cfg.OpTimeout,
+		kubeClient:          kubeClient,
+		dialer:              radosDialer{},
+		clock:               clock.RealClock{},
+		backoff: wait.Backoff{
+			Duration: reconnectInitialBackoff,
+			Factor:   2,
+			Jitter:   0.1,
+			Steps:    math.MaxInt32,
+			Cap:      reconnectMaxBackoff,
+		},
+		reconnectCh: make(chan struct{}, 1),
+		healthErr:   errNotConnected,
+	}
+}
+
+// String returns the cluster ID, or the monitors of the cluster without ID.
+func (c *cluster) String() string {
+	if c.ClusterID != "" {
+		return c.ClusterID
+	}
+	return c.Monitors
+}
+
+// label returns the metrics label of the cluster.
+func (c *cluster) label() string {
+	if c.ClusterID != "" {
+		return c.ClusterID
+	}
+	return defaultClusterLabel
+}
+
+// start connects to the Ceph cluster and starts supervising the connection.
+// If the initial connection fails, the cluster keeps reconnecting in the
+// background.
+func (c *cluster) start() error {
+	conn, err := c.dial()
+	if err == nil {
+		c.setConn(conn)
+		klog.V(4).Infof("Connected to Ceph cluster %s", c)
+	}
+	c.setHealth(err)
+
+	c.stopCh = make(chan struct{})
+	if c.SecretRef != nil {
+		c.watchSecret(c.stopCh)
+	}
+	go c.supervise(c.stopCh)
+	return err
+}
+
+// stop stops supervising and closes the connection to the Ceph cluster.
+func (c *cluster) stop() {
+	if c.stopCh != nil {
+		close(c.stopCh)
+		c.stopCh = nil
+	}
+	c.setConn(nil)
+	c.setHealth(errNotConnected)
+	klog.V(4).Infof("Disconnected to Ceph cluster %s", c)
+}
+
+// health returns the result of the last health check of the supervisor.
+func (c *cluster) health() error {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	return c.healthErr
+}
+
+// setHealth records the result of the health check.
+func (c *cluster) setHealth(err error) {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	c.healthErr = err
+}
+
+// ping checks the connectivity to the Ceph cluster by getting the cluster stats.
+func (c *cluster) ping() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conn == nil {
+		return errNotConnected
+	}
+	if _, err := c.conn.GetClusterStats(); err != nil {
+		return fmt.Errorf("getting Ceph cluster stats failed: %w", err)
+	}
+	return nil
+}
+
+// openIOContext opens an IOContext for the pool and RADOS namespace of the
+// image, the caller must hold the read lock to keep the connection from being
+// replaced until done.
+func (c *cluster) openIOContext(loc imageLocation) (*rados.IOContext, error) {
+	if c.conn == nil {
+		return nil, errNotConnected
+	}
+	ioctx, err := c.conn.OpenIOContext(loc.Pool)
+	if err != nil {
+		return nil, err
+	}
+	if loc.Namespace != "" {
+		ioctx.SetNamespace(loc.Namespace)
+	}
+	return ioctx, nil
+}
+
+// newConn creates a new connection to the Ceph cluster with the user and key
+// and connects it. The key is kept in memory rather than written to a keyfile.
+func newConn(monitors, user, key string, opTimeout time.Duration) (*rados.Conn, error) {
+	conn, err := rados.NewConnWithUser(user)
+	if err != nil {
+		return nil, fmt.Errorf("creating a new Ceph connection failed: %w", err)
+	}
+
+	args := []string{"-m", monitors}
+	if err := conn.ParseCmdLineArgs(args); err != nil {
+		return nil, fmt.Errorf("parsing cmdline args (%v) failed: %w", args, err)
+	}
+	if err := conn.SetConfigOption("key", key); err != nil {
+		return nil, fmt.Errorf("setting Ceph user key failed: %w", err)
+	}
+
+	// Bound the operations, otherwise they hang forever while the cluster is unreachable.
+	if opTimeout > 0 {
+		timeout := strconv.Itoa(int(math.Ceil(opTimeout.Seconds())))
+		for _, opt := range []string{"rados_mon_op_timeout", "rados_osd_op_timeout", "client_mount_timeout"} {
+			if err := conn.SetConfigOption(opt, timeout); err != nil {
+				return nil, fmt.Errorf("setting Ceph config option %s=%s failed: %w", opt, timeout, err)
+			}
+		}
+	}
+
+	if err := conn.Connect(); err != nil {
+		conn.Shutdown()
+		return nil, fmt.Errorf("connecting Ceph cluster failed: %w", err)
+	}
+	return conn, nil
+}
+
+// dial creates a new connection to the Ceph cluster with the current credentials.
+func (c *cluster) dial() (radosConn, error) {
+	user, key, err := c.credentials()
+	if err != nil {
+		return nil, err
+	}
+	return c.dialer.Dial(c.Monitors, user, key, c.opTimeout)
+}
+
+// setConn replaces the connection to the Ceph cluster, shutting down the old
+// one once the in-flight operations holding the read lock are done.
+func (c *cluster) setConn(conn radosConn) {
+	if c.beforeSwap != nil {
+		c.beforeSwap()
+	}
+	c.mu.Lock()
+	old := c.conn
+	c.conn = conn
+	c.mu.Unlock()
+
+	if old != nil {
+		old.Shutdown()
+	}
+	up := 0.0
+	if conn != nil {
+		up = 1
+	}
+	metrics.BackendConnected.WithLabelValues(c.provisioner, c.label()).Set(up)
+}
+
+// connected reports whether the cluster has a connection.
+func (c *cluster) connected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn != nil
+}
+
+// supervise pings the Ceph cluster periodically, and rebuilds the connection
+// once it goes bad or the credentials change until the stop channel is closed.
+func (c *cluster) supervise(stopCh <-chan struct{}) {
+	interval := c.healthCheckInterval
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	if !c.connected() && !c.reconnect(stopCh) {
+		return
+	}
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-c.reconnectCh:
+			klog.Infof("Reconnecting to Ceph cluster %s with the new credentials", c)
+		case <-ticker.C():
+			err := c.ping()
+			c.setHealth(err)
+			if err == nil {
+				continue
+			}
+			klog.Errorf("Lost connection to Ceph cluster %s: %v, reconnecting", c, err)
+			metrics.BackendConnected.WithLabelValues(c.provisioner, c.label()).Set(0)
+		}
+
+		if !c.reconnect(stopCh) {
+			return
+		}
+	}
+}
+
+// reconnect rebuilds the connection to the Ceph cluster with exponential
+// backoff, it returns false if stopped before connected.
+func (c *cluster) reconnect(stopCh <-chan struct{}) bool {
+	backoff := c.backoff
+	for {
+		metrics.BackendReconnectsTotal.WithLabelValues(c.provisioner, c.label()).Inc()
+		conn, err := c.dial()
+		if err == nil {
+			select {
+			case <-stopCh:
+				// Closed while connecting.
+				conn.Shutdown()
+				return false
+			default:
+			}
+			c.setConn(conn)
+			c.setHealth(nil)
+			klog.Infof("Reconnected to Ceph cluster %s", c)
+			return true
+		}
+		c.setHealth(err)
+
+		delay := backoff.Step()
+		klog.Errorf("Failed to reconnect to Ceph cluster %s: %v, retrying in %v", c, err, delay)
+		select {
+		case <-stopCh:
+			return false
+		case <-c.clock.After(delay):
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package ceph
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// fakeConn is a connection to the Ceph cluster without librados.
+type fakeConn struct {
+	pingErr error
+
+	mu       sync.Mutex
+	shutdown bool
+}
+
+func (c *fakeConn) GetClusterStats() (rados.ClusterStat, error) {
+	return rados.ClusterStat{}, c.pingErr
+}
+
+func (c *fakeConn) OpenIOContext(_ string) (*rados.IOContext, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeConn) Shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shutdown = true
+}
+
+func (c *fakeConn) isShutdown() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.shutdown
+}
+
+// fakeDialer fails the dials with the errors in order, and succeeds afterwards.
+type fakeDialer struct {
+	errs []error
+
+	mu    sync.Mutex
+	dials int
+	conns []*fakeConn
+}
+
+func (d *fakeDialer) Dial(_, _, _ string, _ time.Duration) (radosConn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	if d.dials <= len(d.errs) {
+		return nil, d.errs[d.dials-1]
+	}
+	conn := &fakeConn{}
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+func (d *fakeDialer) dialed() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
+
+// lastConn returns the last connection dialed.
+func (d *fakeDialer) lastConn() radosConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.conns) == 0 {
+		return nil
+	}
+	return d.conns[len(d.conns)-1]
+}
+
+// currentConn returns the connection in use.
+func (c *cluster) currentConn() radosConn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+func newTestCluster(dialer *fakeDialer, clock *testingclock.FakeClock) *cluster {
+	kubeClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-rbd-secret", Namespace: "ceph"},
+		Data:       map[string][]byte{DefaultUserKeyKey: []byte("secret-key")},
+	})
+	c := newCluster(DefaultCephRBDConfig(), &ClusterConfig{
+		ClusterID: "ceph",
+		Monitors:  "192.168.0.1:6789",
+		SecretRef: &SecretRef{Name: "csi-rbd-secret", Namespace: "ceph"},
+	}, kubeClient)
+	c.healthCheckInterval = time.Minute
+	c.dialer = dialer
+	c.clock = clock
+	c.backoff.Jitter = 0
+	c.backoff.Cap = 4 * time.Second
+	return c
+}
+
+// waitFor waits for the condition to hold.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	if err := wait.PollImmediate(time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return condition(), nil
+	}); err != nil {
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func Test_cluster_reconnect(t *testing.T) {
+	failed := errors.New("connection refused")
+	dialer := &fakeDialer{errs: []error{failed, failed, failed, failed}}
+	clock := testingclock.NewFakeClock(time.Now())
+	c := newTestCluster(dialer, clock)
+
+	done := make(chan bool)
+	go func() {
+		done <- c.reconnect(make(chan struct{}))
+	}()
+	// The delays double up to the cap.
+	for i, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		waitFor(t, "the backoff", func() bool {
+			return dialer.dialed() == i+1 && clock.HasWaiters()
+		})
+		clock.Step(delay - time.Millisecond)
+		if got := dialer.dialed(); got != i+1 {
+			t.Fatalf("reconnect() dialed %d times before the backoff %v passed, want %d", got, delay, i+1)
+		}
+		clock.Step(time.Millisecond)
+	}
+	if !<-done {
+		t.Fatal("reconnect() = false, want true")
+	}
+	if got := dialer.dialed(); got != 5 {
+		t.Errorf("reconnect() dialed %d times, want 5", got)
+	}
+	if c.currentConn() != dialer.lastConn() {
+		t.Error("reconnect() did not set the new connection")
+	}
+}
+
+func Test_cluster_reconnect_stopped(t *testing.T) {
+	dialer := &fakeDialer{errs: []error{errors.New("connection refused")}}
+	clock := testingclock.NewFakeClock(time.Now())
+	c := newTestCluster(dialer, clock)
+
+	stopCh := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		done <- c.reconnect(stopCh)
+	}()
+	waitFor(t, "the backoff", clock.HasWaiters)
+	close(stopCh)
+	if <-done {
+		t.Error("reconnect() = true, want false")
+	}
+	if c.connected() {
+		t.Error("reconnect() set a connection after stopped")
+	}
+}
+
+func Test_cluster_supervise(t *testing.T) {
+	tests := []struct {
+		name string
+		// requests is the number of the reconnections requested.
+		requests int
+		pingErr  error
+	}{
+		{
+			name:     "coalesced requests",
+			requests: 3,
+		},
+		{
+			name:    "ping failed",
+			pingErr: errors.New("timed out"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &fakeDialer{}
+			clock := testingclock.NewFakeClock(time.Now())
+			c := newTestCluster(dialer, clock)
+			old := &fakeConn{pingErr: tt.pingErr}
+			c.setConn(old)
+			for i := 0; i < tt.requests; i++ {
+				c.requestReconnect()
+			}
+
+			stopCh := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				c.supervise(stopCh)
+			}()
+			if tt.pingErr != nil {
+				waitFor(t, "the ticker", clock.HasWaiters)
+				clock.Step(c.healthCheckInterval)
+			}
+			waitFor(t, "the reconnection", func() bool {
+				return dialer.dialed() == 1 && c.currentConn() == dialer.lastConn()
+			})
+			if !old.isShutdown() {
+				t.Error("supervise() did not shut down the old connection")
+			}
+			close(stopCh)
+			<-done
+			if got := dialer.dialed(); got != 1 {
+				t.Errorf("supervise() dialed %d times, want 1", got)
+			}
+			if n := len(c.reconnectCh); n != 0 {
+				t.Errorf("supervise() left %d reconnection requests, want 0", n)
+			}
+		})
+	}
+}
+
+func Test_cluster_setConn(t *testing.T) {
+	c := newTestCluster(&fakeDialer{}, testingclock.NewFakeClock(time.Now()))
+	old, conn := &fakeConn{}, &fakeConn{}
+	c.setConn(old)
+
+	// An in-flight operation holds the read lock, so setConn blocks right
+	// after signalling.
+	blocking := make(chan struct{})
+	c.beforeSwap = func() { close(blocking) }
+	c.mu.RLock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.setConn(conn)
+	}()
+	<-blocking
+	if old.isShutdown() {
+		t.Error("setConn() shut down the connection in use")
+	}
+	if c.conn != old {
+		t.Error("setConn() replaced the connection in use")
+	}
+	c.mu.RUnlock()
+
+	<-done
+	if !old.isShutdown() {
+		t.Error("setConn() did not shut down the old connection")
+	}
+	if c.currentConn() != conn {
+		t.Error("setConn() did not set the new connection")
+	}
+}
+
+func Test_cluster_health(t *testing.T) {
+	failed := errors.New("connection refused")
+	dialer := &fakeDialer{errs: []error{failed}}
+	clock := testingclock.NewFakeClock(time.Now())
+	c := newTestCluster(dialer, clock)
+	if err := c.health(); err != errNotConnected {
+		t.Errorf("health() = %v before connected, want %v", err, errNotConnected)
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- c.reconnect(make(chan struct{}))
+	}()
+	waitFor(t, "the backoff", clock.HasWaiters)
+	if err := c.health(); err != failed {
+		t.Errorf("health() = %v after failed to connect, want %v", err, failed)
+	}
+	clock.Step(time.Second)
+	<-done
+	if err := c.health(); err != nil {
+		t.Errorf("health() = %v after connected, want nil", err)
+	}
+
+	c.stop()
+	if err := c.health(); err != errNotConnected {
+		t.Errorf("health() = %v after stopped, want %v", err, errNotConnected)
+	}
+}
@@ -0,0 +1,263 @@
+package ceph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultCSIConfigKey = "config.json"
+
+	// The prefixes of the StorageClass parameters referencing the ceph-csi
+	// Secrets, in the order of preference.
+	paramControllerExpandSecretPrefix = "csi.storage.k8s.io/controller-expand-secret"
+	paramProvisionerSecretPrefix      = "csi.storage.k8s.io/provisioner-secret"
+
+	csiConfigSyncTimeout = 30 * time.Second
+)
+
+type (
+	// CSIConfigRef references the ConfigMap of ceph-csi containing the
+	// monitors of the clusters, e.g. ceph-csi-config.
+	CSIConfigRef struct {
+		Name      string `json:"name" yaml:"name"`
+		Namespace string `json:"namespace" yaml:"namespace"`
+		// Key is the key of the JSON config in the ConfigMap, "config.json" by default.
+		Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	}
+	// csiClusterInfo is an entry of the ceph-csi config.
+	csiClusterInfo struct {
+		ClusterID string   `json:"clusterID"`
+		Monitors  []string `json:"monitors"`
+	}
+	// csiClusters are the clusters resolved from the ceph-csi config and the
+	// Secrets of the PVs, which are connected on demand and cached.
+	csiClusters struct {
+		ref        *CSIConfigRef
+		cfg        *RBDManagerConfig
+		kubeClient kubernetes.Interface
+
+		cmLister corelisters.ConfigMapLister
+		// scLister is shared with the controller, which syncs it before
+		// processing any PV.
+		scLister storagelisters.StorageClassLister
+
+		mu sync.Mutex
+		// clusters are keyed by clusterID and the Secret.
+		clusters map[string]*cluster
+	}
+)
+
+func (r *CSIConfigRef) key() string {
+	if r.Key == "" {
+		return DefaultCSIConfigKey
+	}
+	return r.Key
+}
+
+func newCSIClusters(cfg *RBDManagerConfig, kubeClient kubernetes.Interface, scLister storagelisters.StorageClassLister) *csiClusters {
+	return &csiClusters{
+		ref:        cfg.CSIConfigRef,
+		cfg:        cfg,
+		kubeClient: kubeClient,
+		scLister:   scLister,
+		clusters:   make(map[string]*cluster),
+	}
+}
+
+// start starts watching the ceph-csi config until the stop channel is closed.
+// It fails if the config is not synced in time, e.g. it is not allowed to be
+// read.
+func (cs *csiClusters) start(stopCh <-chan struct{}) error {
+	if cs.kubeClient == nil {
+		return fmt.Errorf("no kube client to read the ceph-csi config")
+	}
+	if cs.scLister == nil {
+		return fmt.Errorf("no StorageClass lister to resolve the ceph-csi Secrets")
+	}
+
+	cmFactory := informers.NewSharedInformerFactoryWithOptions(cs.kubeClient, 0,
+		informers.WithNamespace(cs.ref.Namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", cs.ref.Name).String()
+		}))
+	cmInformer := cmFactory.Core().V1().ConfigMaps()
+	cmInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			if !reflect.DeepEqual(old.(*corev1.ConfigMap).Data, new.(*corev1.ConfigMap).Data) {
+				cs.prune(new.(*corev1.ConfigMap))
+			}
+		},
+		DeleteFunc: func(_ interface{}) {
+			cs.prune(nil)
+		},
+	})
+	cmFactory.Start(stopCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), csiConfigSyncTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if !cache.WaitForCacheSync(ctx.Done(), cmInformer.Informer().HasSynced) {
+		return fmt.Errorf("failed to wait for the ceph-csi config %s/%s to sync, check if it is allowed to be read",
+			cs.ref.Namespace, cs.ref.Name)
+	}
+	cs.cmLister = cmInformer.Lister()
+	return nil
+}
+
+// stop closes the connections to all the clusters.
+func (cs *csiClusters) stop() {
+	for _, c := range cs.list() {
+		c.stop()
+	}
+}
+
+// list returns all the cached clusters.
+func (cs *csiClusters) list() []*cluster {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	clusters := make([]*cluster, 0, len(cs.clusters))
+	for _, c := range cs.clusters {
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// get returns the cluster of the PV, which is connected on demand.
+func (cs *csiClusters) get(pv *corev1.PersistentVolume) (*cluster, error) {
+	if cs.cmLister == nil {
+		return nil, errNotConnected
+	}
+	id := pv.Spec.CSI.VolumeAttributes["clusterID"]
+	if id == "" {
+		return nil, fmt.Errorf("PV %s missing clusterID in volumeAttributes", pv.Name)
+	}
+	cm, err := cs.cmLister.ConfigMaps(cs.ref.Namespace).Get(cs.ref.Name)
+	if err != nil {
+		return nil, fmt.Errorf("getting ceph-csi config %s/%s failed: %w", cs.ref.Namespace, cs.ref.Name, err)
+	}
+	monitors, err := csiMonitors(cm, cs.ref.key(), id)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := cs.secretRef(pv)
+	if err != nil {
+		return nil, err
+	}
+
+	key := id + "/" + secret.Namespace + "/" + secret.Name
+	cs.mu.Lock()
+	stale, ok := cs.clusters[key]
+	if ok && stale.Monitors == monitors {
+		cs.mu.Unlock()
+		return stale, nil
+	}
+	c := newCluster(cs.cfg, &ClusterConfig{ClusterID: id, Monitors: monitors, SecretRef: secret}, cs.kubeClient)
+	cs.clusters[key] = c
+	cs.mu.Unlock()
+	if ok {
+		stale.stop()
+	}
+
+	// The cluster keeps reconnecting in the background if failed.
+	if err := c.start(); err != nil {
+		return nil, fmt.Errorf("connecting Ceph cluster %s failed: %w", id, err)
+	}
+	return c, nil
+}
+
+// prune closes the clusters whose monitors have been changed or removed from
+// the ceph-csi config, they are reconnected with the new monitors on demand.
+func (cs *csiClusters) prune(cm *corev1.ConfigMap) {
+	var stale []*cluster
+	cs.mu.Lock()
+	for key, c := range cs.clusters {
+		if cm != nil {
+			if monitors, err := csiMonitors(cm, cs.ref.key(), c.ClusterID); err == nil && monitors == c.Monitors {
+				continue
+			}
+		}
+		delete(cs.clusters, key)
+		stale = append(stale, c)
+	}
+	cs.mu.Unlock()
+
+	for _, c := range stale {
+		klog.Infof("Monitors of Ceph cluster %s changed in the ceph-csi config, reconnecting on demand", c)
+		c.stop()
+	}
+}
+
+// secretRef returns the Secret of the PV's credentials, which is the
+// controller expand Secret of the PV, or the one referenced by its StorageClass.
+func (cs *csiClusters) secretRef(pv *corev1.PersistentVolume) (*SecretRef, error) {
+	if ref := pv.Spec.CSI.ControllerExpandSecretRef; ref != nil {
+		return &SecretRef{Name: ref.Name, Namespace: ref.Namespace}, nil
+	}
+	if name := pv.Spec.StorageClassName; name != "" {
+		sc, err := cs.scLister.Get(name)
+		if err == nil {
+			if ref := storageClassSecretRef(sc); ref != nil {
+				return ref, nil
+			}
+		} else if !errors.IsNotFound(err) {
+			utilruntime.HandleError(err)
+		}
+	}
+	return nil, fmt.Errorf("no ceph-csi Secret found for PV %s", pv.Name)
+}
+
+// storageClassSecretRef returns the ceph-csi Secret referenced by the
+// parameters of the StorageClass, the templated ones are skipped.
+func storageClassSecretRef(sc *storagev1.StorageClass) *SecretRef {
+	for _, prefix := range []string{paramControllerExpandSecretPrefix, paramProvisionerSecretPrefix} {
+		name, namespace := sc.Parameters[prefix+"-name"], sc.Parameters[prefix+"-namespace"]
+		if name == "" || namespace == "" || strings.Contains(name+namespace, "${") {
+			continue
+		}
+		return &SecretRef{Name: name, Namespace: namespace}
+	}
+	return nil
+}
+
+// csiMonitors returns the monitors of the cluster in the ceph-csi config.
+func csiMonitors(cm *corev1.ConfigMap, key, clusterID string) (string, error) {
+	data, ok := cm.Data[key]
+	if !ok {
+		return "", fmt.Errorf("ceph-csi config %s/%s missing key %q", cm.Namespace, cm.Name, key)
+	}
+	var infos []csiClusterInfo
+	if err := json.Unmarshal([]byte(data), &infos); err != nil {
+		return "", fmt.Errorf("invalid ceph-csi config %s/%s: %w", cm.Namespace, cm.Name, err)
+	}
+	for _, info := range infos {
+		if info.ClusterID == clusterID && len(info.Monitors) > 0 {
+			return strings.Join(info.Monitors, ","), nil
+		}
+	}
+	return "", fmt.Errorf("Ceph cluster %q not found in ceph-csi config %s/%s", clusterID, cm.Namespace, cm.Name)
+}
@@ -0,0 +1,143 @@
+package ceph
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_csiMonitors(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ceph-csi-config", Namespace: "ceph-csi"},
+		Data: map[string]string{
+			DefaultCSIConfigKey: `[
+				{"clusterID": "a", "monitors": ["10.0.0.1:6789", "10.0.0.2:6789"]},
+				{"clusterID": "b", "monitors": []}
+			]`,
+			"invalid": "{",
+		},
+	}
+	tests := []struct {
+		name      string
+		key       string
+		clusterID string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "found",
+			key:       DefaultCSIConfigKey,
+			clusterID: "a",
+			want:      "10.0.0.1:6789,10.0.0.2:6789",
+		},
+		{
+			name:      "no monitors",
+			key:       DefaultCSIConfigKey,
+			clusterID: "b",
+			wantErr:   true,
+		},
+		{
+			name:      "not found",
+			key:       DefaultCSIConfigKey,
+			clusterID: "c",
+			wantErr:   true,
+		},
+		{
+			name:      "missing key",
+			key:       "missing",
+			clusterID: "a",
+			wantErr:   true,
+		},
+		{
+			name:      "invalid JSON",
+			key:       "invalid",
+			clusterID: "a",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := csiMonitors(cm, tt.key, tt.clusterID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("csiMonitors() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("csiMonitors() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_csiClusters_secretRef(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, sc := range []*storagev1.StorageClass{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "provisioner"},
+			Parameters: map[string]string{
+				paramProvisionerSecretPrefix + "-name":      "csi-rbd-provisioner",
+				paramProvisionerSecretPrefix + "-namespace": "ceph-csi",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "templated"},
+			Parameters: map[string]string{
+				paramControllerExpandSecretPrefix + "-name":      "${pvc.name}",
+				paramControllerExpandSecretPrefix + "-namespace": "ceph-csi",
+			},
+		},
+	} {
+		if err := indexer.Add(sc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs := &csiClusters{scLister: storagelisters.NewStorageClassLister(indexer)}
+
+	newPV := func(storageClass string, ref *corev1.SecretReference) *corev1.PersistentVolume {
+		pv := newRBDPV(nil)
+		pv.Spec.StorageClassName = storageClass
+		pv.Spec.CSI.ControllerExpandSecretRef = ref
+		return pv
+	}
+	tests := []struct {
+		name    string
+		pv      *corev1.PersistentVolume
+		want    *SecretRef
+		wantErr bool
+	}{
+		{
+			name: "controller expand Secret of PV",
+			pv:   newPV("provisioner", &corev1.SecretReference{Name: "csi-rbd-expand", Namespace: "ceph-csi"}),
+			want: &SecretRef{Name: "csi-rbd-expand", Namespace: "ceph-csi"},
+		},
+		{
+			name: "provisioner Secret of StorageClass",
+			pv:   newPV("provisioner", nil),
+			want: &SecretRef{Name: "csi-rbd-provisioner", Namespace: "ceph-csi"},
+		},
+		{
+			name:    "templated Secret",
+			pv:      newPV("templated", nil),
+			wantErr: true,
+		},
+		{
+			name:    "StorageClass not found",
+			pv:      newPV("missing", nil),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cs.secretRef(tt.pv)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("secretRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != nil && (got == nil || *got != *tt.want) {
+				t.Errorf("secretRef() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,16 +1,21 @@
 package ceph
 
 import (
+	"encoding/json"
+	"fmt"
 	"regexp"
+	"strconv"
 
 	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
 const (
-	qosPattern = `^[1-9][0-9]*(M|G|T)?$`
+	tickPattern = `^[1-9][0-9]*$`
 )
 
-var qosReg *regexp.Regexp = regexp.MustCompile(qosPattern)
+var tickReg *regexp.Regexp = regexp.MustCompile(tickPattern)
 
 // getQoSRulesFromMeta extracts QoS rules map from rbd image metadata
 func getQoSRulesFromMeta(meta map[string]string) RBDQoSRules {
@@ -27,6 +32,21 @@ func getQoSRulesFromMeta(meta map[string]string) RBDQoSRules {
 func rbdQoSRules(settings vm.QoSSettings) RBDQoSRules {
 	rules := make(RBDQoSRules)
 	for k, v := range settings {
+		switch {
+		case vm.IsQoSValueUnlimited(v):
+			// The unlimited settings are removed from the image.
+			continue
+		case vm.IsQoSBurstSecondsKey(k):
+			// librbd takes the burst seconds as a number of seconds.
+			if seconds, err := vm.ParseQoSDuration(v); err == nil {
+				v = strconv.FormatInt(seconds, 10)
+			}
+		case k != vm.QoSRBDScheduleTickMinKey:
+			// librbd takes the rates as plain numbers.
+			if n, err := vm.ParseQoSValue(v); err == nil {
+				v = strconv.FormatInt(n, 10)
+			}
+		}
 		rules[QoSKeyMap[k]] = v
 	}
 	return rules
@@ -56,7 +76,114 @@ func calRemove(cur, spec RBDQoSRules) RBDQoSRules {
 	return rules
 }
 
+// qosOwnership records the QoS rules of an image set by the controller, which
+// is stored in the image metadata of RBDQoSOwnerKey.
+type qosOwnership struct {
+	// Rules are the owned QoS rules with their values when they were set.
+	Rules RBDQoSRules `json:"rules"`
+	// Hash is the hash of the rules, which tells whether the record itself
+	// has been changed by hand.
+	Hash string `json:"hash"`
+}
+
+// newQoSOwnership returns the ownership of the QoS rules.
+func newQoSOwnership(rules RBDQoSRules) *qosOwnership {
+	return &qosOwnership{Rules: rules, Hash: vm.HashRules(rules)}
+}
+
+// getQoSOwnership extracts the ownership of the QoS rules from rbd image
+// metadata, nil if the controller has never set the rules or the record is
+// corrupted.
+func getQoSOwnership(meta map[string]string) *qosOwnership {
+	v, ok := meta[RBDQoSOwnerKey]
+	if !ok {
+		return nil
+	}
+	o := &qosOwnership{}
+	if err := json.Unmarshal([]byte(v), o); err != nil || vm.HashRules(o.Rules) != o.Hash {
+		return nil
+	}
+	return o
+}
+
+// String returns the JSON format of the ownership.
+func (o *qosOwnership) String() string {
+	// json.Marshal sorts the map keys, so the value is stable.
+	b, _ := json.Marshal(o)
+	return string(b)
+}
+
+// split splits the existing QoS rules recorded by the ownership into the ones
+// still owned, whose values are unchanged since they were set, and the ones
+// changed by hand since, which are no longer owned.
+func (o *qosOwnership) split(cur RBDQoSRules) (owned, changed RBDQoSRules) {
+	owned, changed = make(RBDQoSRules), make(RBDQoSRules)
+	if o == nil {
+		return
+	}
+	for k, v := range o.Rules {
+		cv, ok := cur[k]
+		switch {
+		case !ok:
+			// Removed by hand, nothing to do with it.
+		case cv == v:
+			owned[k] = cv
+		default:
+			changed[k] = cv
+		}
+	}
+	return
+}
+
 // isQoSValueValid checks if the QoS setting value is valid
 func isQoSValueValid(v string) bool {
-	return qosReg.MatchString(v)
+	_, err := vm.ParseQoSValue(v)
+	return err == nil
+}
+
+// isTickValid checks if the schedule tick value in milliseconds is valid
+func isTickValid(v string) bool {
+	return tickReg.MatchString(v)
+}
+
+// imageLocation locates the RBD image of a PV.
+type imageLocation struct {
+	Pool string
+	// Namespace is the RADOS namespace of the image, empty for the default one.
+	Namespace string
+	Image     string
+}
+
+// String returns the image spec in the format of pool[/namespace]/image.
+func (l imageLocation) String() string {
+	if l.Namespace == "" {
+		return l.Pool + "/" + l.Image
+	}
+	return l.Pool + "/" + l.Namespace + "/" + l.Image
+}
+
+// volumeLocation returns the location of the RBD image from the volume
+// attributes of the ceph-csi PV. The image of a statically provisioned PV is
+// named by its volume handle.
+func volumeLocation(pv *corev1.PersistentVolume) (imageLocation, error) {
+	if pv.Spec.CSI == nil {
+		return imageLocation{}, fmt.Errorf("PV %s is not a CSI volume", pv.Name)
+	}
+	attrs := pv.Spec.CSI.VolumeAttributes
+	image, ok := attrs["imageName"]
+	if !ok && attrs["staticVolume"] == "true" {
+		image, ok = pv.Spec.CSI.VolumeHandle, pv.Spec.CSI.VolumeHandle != ""
+	}
+	if !ok {
+		return imageLocation{}, fmt.Errorf("invalid PV %s missing imageName in volumeAttributes", pv.Name)
+	}
+	pool, ok := attrs["pool"]
+	if !ok {
+		return imageLocation{}, fmt.Errorf("invalid PV %s missing pool in volumeAttributes", pv.Name)
+	}
+	return imageLocation{
+		Pool:      pool,
+		Namespace: attrs["radosNamespace"],
+		Image:     image,
+	}, nil
 }
@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
 func Test_getQoSRulesFromMeta(t *testing.T) {
@@ -90,6 +92,39 @@ func Test_rbdQoSRules(t *testing.T) {
 				RBDQoSLimitWriteIOPSKey: "1",
 			},
 		},
+		{
+			name: "burst seconds and tick",
+			args: args{
+				settings: vm.QoSSettings{
+					vm.QoSBurstIOPSKey:          "1000",
+					vm.QoSBurstSecondsIOPSKey:   "2m",
+					vm.QoSBurstSecondsBPSKey:    "30",
+					vm.QoSRBDScheduleTickMinKey: "100",
+				},
+			},
+			want: RBDQoSRules{
+				RBDQoSBurstIOPSKey:        "1000",
+				RBDQoSBurstSecondsIOPSKey: "120",
+				RBDQoSBurstSecondsBPSKey:  "30",
+				RBDQoSScheduleTickMinKey:  "100",
+			},
+		},
+		{
+			name: "quantities and unlimited",
+			args: args{
+				settings: vm.QoSSettings{
+					vm.QoSLimitBPSKey:        "100Mi",
+					vm.QoSLimitReadBPSKey:    "1.5G",
+					vm.QoSLimitWriteBPSKey:   "unlimited",
+					vm.QoSLimitIOPSKey:       "0",
+					vm.QoSBurstSecondsBPSKey: "0",
+				},
+			},
+			want: RBDQoSRules{
+				RBDQoSLimitBPSKey:     "104857600",
+				RBDQoSLimitReadBPSKey: "1500000000",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -297,7 +332,7 @@ func Test_isQoSValueValid(t *testing.T) {
 			args: args{
 				unit: "01",
 			},
-			want: false,
+			want: true,
 		},
 		{
 			name: "test 3",
@@ -339,7 +374,7 @@ func Test_isQoSValueValid(t *testing.T) {
 			args: args{
 				unit: "100K",
 			},
-			want: false,
+			want: true,
 		},
 		{
 			name: "test 9",
@@ -360,7 +395,7 @@ func Test_isQoSValueValid(t *testing.T) {
 			args: args{
 				unit: "100Mi",
 			},
-			want: false,
+			want: true,
 		},
 		{
 			name: "test 12",
@@ -392,3 +427,217 @@ func Test_isQoSValueValid(t *testing.T) {
 		})
 	}
 }
+
+func Test_volumeLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		pv       *corev1.PersistentVolume
+		want     imageLocation
+		wantSpec string
+		wantErr  bool
+	}{
+		{
+			name:     "default namespace",
+			pv:       newRBDPV(map[string]string{"pool": "rbd", "imageName": "csi-vol-1"}),
+			want:     imageLocation{Pool: "rbd", Image: "csi-vol-1"},
+			wantSpec: "rbd/csi-vol-1",
+		},
+		{
+			name: "RADOS namespace",
+			pv: newRBDPV(map[string]string{
+				"pool": "rbd", "radosNamespace": "tenant-a", "imageName": "csi-vol-1",
+			}),
+			want:     imageLocation{Pool: "rbd", Namespace: "tenant-a", Image: "csi-vol-1"},
+			wantSpec: "rbd/tenant-a/csi-vol-1",
+		},
+		{
+			name:    "missing pool",
+			pv:      newRBDPV(map[string]string{"imageName": "csi-vol-1"}),
+			wantErr: true,
+		},
+		{
+			name: "static volume",
+			pv: func() *corev1.PersistentVolume {
+				pv := newRBDPV(map[string]string{"pool": "rbd", "staticVolume": "true"})
+				pv.Spec.CSI.VolumeHandle = "static-image"
+				return pv
+			}(),
+			want:     imageLocation{Pool: "rbd", Image: "static-image"},
+			wantSpec: "rbd/static-image",
+		},
+		{
+			name:    "missing imageName",
+			pv:      newRBDPV(map[string]string{"pool": "rbd"}),
+			wantErr: true,
+		},
+		{
+			name:    "not CSI",
+			pv:      &corev1.PersistentVolume{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := volumeLocation(tt.pv)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("volumeLocation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("volumeLocation() = %+v, want %+v", got, tt.want)
+			}
+			if err == nil && got.String() != tt.wantSpec {
+				t.Errorf("volumeLocation().String() = %v, want %v", got.String(), tt.wantSpec)
+			}
+		})
+	}
+}
+
+func TestCephRBDManager_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings vm.QoSSettings
+		wantErr  bool
+	}{
+		{
+			name: "valid",
+			settings: vm.QoSSettings{
+				vm.QoSLimitBPSKey:           "100M",
+				vm.QoSBurstSecondsBPSKey:    "1m",
+				vm.QoSBurstSecondsIOPSKey:   "10",
+				vm.QoSRBDScheduleTickMinKey: "50",
+			},
+		},
+		{
+			name:     "invalid rate",
+			settings: vm.QoSSettings{vm.QoSLimitIOPSKey: "30s"},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid burst seconds",
+			settings: vm.QoSSettings{vm.QoSBurstSecondsIOPSKey: "100M"},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid tick",
+			settings: vm.QoSSettings{vm.QoSRBDScheduleTickMinKey: "1s"},
+			wantErr:  true,
+		},
+	}
+	m := &CephRBDManager{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.Validate(tt.settings); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("every invalid key", func(t *testing.T) {
+		err := m.Validate(vm.QoSSettings{
+			vm.QoSLimitIOPSKey:          "30s",
+			vm.QoSLimitBPSKey:           "100M",
+			vm.QoSRBDScheduleTickMinKey: "1s",
+			vm.QoSBurstSecondsIOPSKey:   "100M",
+		})
+		verr, ok := err.(*vm.ValidationError)
+		if !ok {
+			t.Fatalf("Validate() error = %v, want *vm.ValidationError", err)
+		}
+		want := []string{vm.QoSBurstSecondsIOPSKey, vm.QoSLimitIOPSKey, vm.QoSRBDScheduleTickMinKey}
+		if got := verr.Keys(); !reflect.DeepEqual(got, want) {
+			t.Errorf("ValidationError.Keys() = %v, want %v", got, want)
+		}
+	})
+}
+
+func Test_getQoSOwnership(t *testing.T) {
+	owner := newQoSOwnership(RBDQoSRules{
+		RBDQoSLimitIOPSKey:    "1000",
+		RBDQoSBurstIOPSKey:    "2000",
+		RBDQoSLimitReadBPSKey: "10000000",
+	})
+	tampered := newQoSOwnership(RBDQoSRules{RBDQoSLimitIOPSKey: "1000"})
+	tampered.Rules = RBDQoSRules{RBDQoSLimitIOPSKey: "2000"}
+	tests := []struct {
+		name string
+		meta map[string]string
+		want *qosOwnership
+	}{
+		{
+			name: "owned",
+			meta: map[string]string{RBDQoSOwnerKey: owner.String()},
+			want: owner,
+		},
+		{
+			name: "never set",
+			meta: map[string]string{RBDQoSLimitIOPSKey: "1000"},
+		},
+		{
+			name: "corrupted",
+			meta: map[string]string{RBDQoSOwnerKey: "{"},
+		},
+		{
+			name: "tampered",
+			meta: map[string]string{RBDQoSOwnerKey: tampered.String()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getQoSOwnership(tt.meta); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getQoSOwnership() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCephRBDManager_ownedRules(t *testing.T) {
+	cur := RBDQoSRules{
+		RBDQoSLimitIOPSKey:     "1000",
+		RBDQoSBurstIOPSKey:     "3000", // changed by hand from 2000
+		RBDQoSLimitReadIOPSKey: "500",  // set by hand
+	}
+	owner := newQoSOwnership(RBDQoSRules{
+		RBDQoSLimitIOPSKey: "1000",
+		RBDQoSBurstIOPSKey: "2000",
+		RBDQoSLimitBPSKey:  "1000000", // removed by hand
+	}).String()
+	tests := []struct {
+		name        string
+		takeOver    bool
+		meta        map[string]string
+		wantOwned   RBDQoSRules
+		wantChanged RBDQoSRules
+	}{
+		{
+			name:        "owned",
+			meta:        map[string]string{RBDQoSOwnerKey: owner},
+			wantOwned:   RBDQoSRules{RBDQoSLimitIOPSKey: "1000"},
+			wantChanged: RBDQoSRules{RBDQoSBurstIOPSKey: "3000"},
+		},
+		{
+			name:        "never set",
+			meta:        map[string]string{},
+			wantOwned:   RBDQoSRules{},
+			wantChanged: RBDQoSRules{},
+		},
+		{
+			name:        "take over",
+			takeOver:    true,
+			meta:        map[string]string{RBDQoSOwnerKey: owner},
+			wantOwned:   cur,
+			wantChanged: RBDQoSRules{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &CephRBDManager{RBDManagerConfig: &RBDManagerConfig{TakeOver: tt.takeOver}}
+			owned, changed := m.ownedRules(tt.meta, cur)
+			if !reflect.DeepEqual(owned, tt.wantOwned) {
+				t.Errorf("ownedRules() owned = %v, want %v", owned, tt.wantOwned)
+			}
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("ownedRules() changed = %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+}
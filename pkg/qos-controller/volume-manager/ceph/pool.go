@@ -0,0 +1,221 @@
+package ceph
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/ceph/go-ceph/rbd"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// PoolDefaults are the QoS defaults of all the images in a pool or a RADOS
+// namespace of the pool, which are stored as the pool config of Ceph like
+// `rbd config pool set`.
+type PoolDefaults struct {
+	// ClusterID selects the cluster of the pool, the default cluster if empty.
+	ClusterID string `json:"cluster_id,omitempty" yaml:"clusterID,omitempty"`
+	Pool      string `json:"pool" yaml:"pool"`
+	// RadosNamespace sets the defaults of the RADOS namespace instead of the pool.
+	RadosNamespace string `json:"rados_namespace,omitempty" yaml:"radosNamespace,omitempty"`
+	// QoS are keyed by the QoS annotation keys without the pv.kubernetes.io/
+	// prefix, e.g. qos-iops-limit.
+	QoS map[string]string `json:"qos" yaml:"qos"`
+}
+
+// location returns the location of the pool or the RADOS namespace.
+func (d *PoolDefaults) location() imageLocation {
+	return imageLocation{Pool: d.Pool, Namespace: d.RadosNamespace}
+}
+
+// String returns the pool spec in the format of pool[/namespace].
+func (d *PoolDefaults) String() string {
+	return strings.TrimSuffix(d.location().String(), "/")
+}
+
+// settings returns the QoS settings keyed by the QoS annotation keys.
+func (d *PoolDefaults) settings() vm.QoSSettings {
+	settings := make(vm.QoSSettings, len(d.QoS))
+	for k, v := range d.QoS {
+		settings[vm.QoSPrefix+k] = v
+	}
+	return settings
+}
+
+// validatePoolDefaults validates the pool defaults in the config.
+func (m *CephRBDManager) validatePoolDefaults() error {
+	for i := range m.PoolDefaults {
+		d := &m.PoolDefaults[i]
+		if d.Pool == "" {
+			return fmt.Errorf("pool of QoS pool defaults is required")
+		}
+		settings := d.settings()
+		for k := range settings {
+			if _, ok := QoSKeyMap[k]; !ok {
+				return fmt.Errorf("invalid QoS pool defaults of %s: unknown QoS key %q", d, strings.TrimPrefix(k, vm.QoSPrefix))
+			}
+		}
+		if err := m.Validate(settings); err != nil {
+			return fmt.Errorf("invalid QoS pool defaults of %s: %v", d, err)
+		}
+	}
+	return nil
+}
+
+// Reconcile sets the QoS pool defaults in the config which have drifted, e.g.
+// changed by `rbd config pool` outside the controller, and removes the ones
+// set by the controller which have been removed from the config or become
+// unlimited. The pool config keys set by hand are left as they are.
+func (m *CephRBDManager) Reconcile() error {
+	var errs []error
+	for i := range m.PoolDefaults {
+		if err := m.reconcilePoolDefaults(&m.PoolDefaults[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (m *CephRBDManager) reconcilePoolDefaults(d *PoolDefaults) error {
+	c, ok := m.clusters[d.ClusterID]
+	if !ok {
+		return fmt.Errorf("unknown Ceph cluster %q of QoS pool defaults of %s", d.ClusterID, d)
+	}
+
+	// Hold the connection from being replaced until done.
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ioctx, err := c.openIOContext(d.location())
+	if err != nil {
+		return fmt.Errorf("failed to open IOContext for pool %s: %w", d, err)
+	}
+	defer ioctx.Destroy()
+	return m.reconcilePoolMetadata(d, c, rbdPoolMetadata{ioctx: ioctx})
+}
+
+// poolMetadata is the metadata of a pool or a RADOS namespace, which is
+// accessed like the metadata of an image.
+type poolMetadata interface {
+	metadataWriter
+	GetMetadata(key string) (string, error)
+}
+
+// rbdPoolMetadata is the pool metadata of librbd.
+type rbdPoolMetadata struct {
+	ioctx *rados.IOContext
+}
+
+func (md rbdPoolMetadata) GetMetadata(key string) (string, error) {
+	return rbd.GetPoolMetadata(md.ioctx, key)
+}
+
+func (md rbdPoolMetadata) SetMetadata(key, value string) error {
+	return rbd.SetPoolMetadata(md.ioctx, key, value)
+}
+
+func (md rbdPoolMetadata) RemoveMetadata(key string) error {
+	return rbd.RemovePoolMetadata(md.ioctx, key)
+}
+
+// listPoolMetadata reads the QoS keys and the ownership record from the pool
+// metadata, the missing ones are left out.
+func listPoolMetadata(md poolMetadata) (map[string]string, error) {
+	keys := []string{RBDQoSOwnerKey}
+	for k := range RBDQoSKeyMap {
+		keys = append(keys, k)
+	}
+	meta := make(map[string]string)
+	for _, k := range keys {
+		v, err := md.GetMetadata(k)
+		if errors.Is(err, rbd.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get metadata %s: %w", k, err)
+		}
+		meta[k] = v
+	}
+	return meta, nil
+}
+
+// reconcilePoolMetadata sets and removes the QoS pool defaults the same way as
+// the QoS rules of images, the ones owned by the controller are recorded in
+// the pool metadata of RBDQoSOwnerKey.
+func (m *CephRBDManager) reconcilePoolMetadata(d *PoolDefaults, c fmt.Stringer, md poolMetadata) error {
+	meta, err := listPoolMetadata(md)
+	if err != nil {
+		return fmt.Errorf("failed to read the QoS defaults of pool %s: %w", d, err)
+	}
+	cur := getQoSRulesFromMeta(meta)
+	spec := rbdQoSRules(d.settings())
+
+	owned, changed := m.ownedRules(meta, cur)
+	set := calSet(cur, spec)
+	remove := calRemove(owned, spec)
+	if disowned := calRemove(changed, spec); len(disowned) > 0 {
+		klog.Warningf("QoS defaults %v of pool %s in Ceph cluster %s have been changed by hand since set, leave them as they are", disowned, d, c)
+	}
+	for _, k := range sortedKeys(set) {
+		if v, ok := cur[k]; ok {
+			klog.Warningf("QoS default %s of pool %s in Ceph cluster %s drifted from %s to %s, resetting", k, d, c, set[k], v)
+		}
+	}
+	if m.DryRun {
+		for _, k := range sortedKeys(set) {
+			klog.Infof("[dry-run] would set metadata for pool %s: %s=%s", d, k, set[k])
+		}
+		for _, k := range sortedKeys(remove) {
+			klog.Infof("[dry-run] would remove metadata for pool %s: %s=%s", d, k, remove[k])
+		}
+		return nil
+	}
+
+	owner := make(RBDQoSRules, len(owned)+len(spec))
+	for k, v := range owned {
+		owner[k] = v
+	}
+	for k, v := range spec {
+		if cur[k] == v {
+			owner[k] = v
+		}
+	}
+	err = func() error {
+		for _, k := range sortedKeys(set) {
+			v := set[k]
+			if err := md.SetMetadata(k, v); err != nil {
+				return fmt.Errorf("failed to set metadata %s=%s for pool %s: %w", k, v, d, err)
+			}
+			owner[k] = v
+			klog.Infof("set metadata for pool %s: %s=%s", d, k, v)
+		}
+		for _, k := range sortedKeys(remove) {
+			v := remove[k]
+			if err := md.RemoveMetadata(k); err != nil && !errors.Is(err, rbd.ErrNotFound) {
+				return fmt.Errorf("failed to remove metadata %s=%s for pool %s: %w", k, v, d, err)
+			}
+			delete(owner, k)
+			klog.Infof("remove metadata for pool %s: %s=%s", d, k, v)
+		}
+		return nil
+	}()
+
+	if oerr := setQoSOwnership(md, meta, owner); oerr != nil && err == nil {
+		err = fmt.Errorf("failed to record the owned QoS defaults of pool %s: %w", d, oerr)
+	}
+	return err
+}
+
+// sortedKeys returns the keys of the rules in order.
+func sortedKeys(rules RBDQoSRules) []string {
+	keys := make([]string, 0, len(rules))
+	for k := range rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,132 @@
+package ceph
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ceph/go-ceph/rbd"
+)
+
+// fakePoolMetadata is the pool metadata kept in memory.
+type fakePoolMetadata map[string]string
+
+func (md fakePoolMetadata) GetMetadata(key string) (string, error) {
+	v, ok := md[key]
+	if !ok {
+		return "", rbd.ErrNotFound
+	}
+	return v, nil
+}
+
+func (md fakePoolMetadata) SetMetadata(key, value string) error {
+	md[key] = value
+	return nil
+}
+
+func (md fakePoolMetadata) RemoveMetadata(key string) error {
+	if _, ok := md[key]; !ok {
+		return rbd.ErrNotFound
+	}
+	delete(md, key)
+	return nil
+}
+
+func TestCephRBDManager_reconcilePoolMetadata(t *testing.T) {
+	owned := func(rules RBDQoSRules) string {
+		return newQoSOwnership(rules).String()
+	}
+	tests := []struct {
+		name   string
+		meta   fakePoolMetadata
+		qos    map[string]string
+		dryRun bool
+		// want are the QoS keys of the pool metadata.
+		want RBDQoSRules
+		// wantOwned are the QoS keys recorded as owned.
+		wantOwned RBDQoSRules
+	}{
+		{
+			name:      "set",
+			meta:      fakePoolMetadata{},
+			qos:       map[string]string{"qos-iops-limit": "1000"},
+			want:      RBDQoSRules{RBDQoSLimitIOPSKey: "1000"},
+			wantOwned: RBDQoSRules{RBDQoSLimitIOPSKey: "1000"},
+		},
+		{
+			name: "unlimited",
+			meta: fakePoolMetadata{
+				RBDQoSLimitIOPSKey: "1000",
+				RBDQoSLimitBPSKey:  "500000000",
+				RBDQoSOwnerKey:     owned(RBDQoSRules{RBDQoSLimitIOPSKey: "1000", RBDQoSLimitBPSKey: "500000000"}),
+			},
+			qos:       map[string]string{"qos-iops-limit": "unlimited", "qos-bps-limit": "500M"},
+			want:      RBDQoSRules{RBDQoSLimitBPSKey: "500000000"},
+			wantOwned: RBDQoSRules{RBDQoSLimitBPSKey: "500000000"},
+		},
+		{
+			name: "removed from config",
+			meta: fakePoolMetadata{
+				RBDQoSLimitIOPSKey: "1000",
+				RBDQoSOwnerKey:     owned(RBDQoSRules{RBDQoSLimitIOPSKey: "1000"}),
+			},
+			qos:  map[string]string{},
+			want: RBDQoSRules{},
+		},
+		{
+			name: "set by hand",
+			meta: fakePoolMetadata{RBDQoSLimitBPSKey: "1000000"},
+			qos:  map[string]string{},
+			want: RBDQoSRules{RBDQoSLimitBPSKey: "1000000"},
+		},
+		{
+			name: "changed by hand",
+			meta: fakePoolMetadata{
+				RBDQoSLimitIOPSKey: "2000",
+				RBDQoSOwnerKey:     owned(RBDQoSRules{RBDQoSLimitIOPSKey: "1000"}),
+			},
+			qos:  map[string]string{},
+			want: RBDQoSRules{RBDQoSLimitIOPSKey: "2000"},
+		},
+		{
+			name: "drifted",
+			meta: fakePoolMetadata{
+				RBDQoSLimitIOPSKey: "2000",
+				RBDQoSOwnerKey:     owned(RBDQoSRules{RBDQoSLimitIOPSKey: "1000"}),
+			},
+			qos:       map[string]string{"qos-iops-limit": "1000"},
+			want:      RBDQoSRules{RBDQoSLimitIOPSKey: "1000"},
+			wantOwned: RBDQoSRules{RBDQoSLimitIOPSKey: "1000"},
+		},
+		{
+			name: "dry run",
+			meta: fakePoolMetadata{
+				RBDQoSLimitIOPSKey: "1000",
+				RBDQoSOwnerKey:     owned(RBDQoSRules{RBDQoSLimitIOPSKey: "1000"}),
+			},
+			qos:       map[string]string{"qos-bps-limit": "500M"},
+			dryRun:    true,
+			want:      RBDQoSRules{RBDQoSLimitIOPSKey: "1000"},
+			wantOwned: RBDQoSRules{RBDQoSLimitIOPSKey: "1000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &CephRBDManager{RBDManagerConfig: &RBDManagerConfig{}}
+			m.DryRun = tt.dryRun
+			d := &PoolDefaults{Pool: "rbd", QoS: tt.qos}
+			if err := m.reconcilePoolMetadata(d, &cluster{ClusterConfig: &ClusterConfig{ClusterID: "ceph"}}, tt.meta); err != nil {
+				t.Fatalf("reconcilePoolMetadata() error = %v", err)
+			}
+			if got := getQoSRulesFromMeta(tt.meta); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reconcilePoolMetadata() pool metadata = %v, want %v", got, tt.want)
+			}
+			o := getQoSOwnership(tt.meta)
+			if (o == nil) != (tt.wantOwned == nil) {
+				t.Fatalf("reconcilePoolMetadata() ownership = %v, want %v", o, tt.wantOwned)
+			}
+			if o != nil && !reflect.DeepEqual(o.Rules, tt.wantOwned) {
+				t.Errorf("reconcilePoolMetadata() owned = %v, want %v", o.Rules, tt.wantOwned)
+			}
+		})
+	}
+}
@@ -2,21 +2,23 @@ package ceph
 
 import (
 	"fmt"
-	"os"
+	"sort"
 	"strings"
+	"time"
 
 	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
 
-	"github.com/ceph/go-ceph/rados"
 	"github.com/ceph/go-ceph/rbd"
 	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/klog/v2"
 )
 
 const (
-	DefaultCSIDriver     = "rbd.csi.ceph.com"
-	tmpKeyFileLocation   = "/tmp"
-	tmpKeyFileNamePrefix = "keyfile-"
+	DefaultCSIDriver = "rbd.csi.ceph.com"
+	BackendName      = "ceph-rbd"
 
 	RBDQoSLimitIOPSKey      = "conf_rbd_qos_iops_limit"
 	RBDQoSLimitReadIOPSKey  = "conf_rbd_qos_read_iops_limit"
@@ -33,6 +35,20 @@ const (
 	RBDQoSBurstBPSKey      = "conf_rbd_qos_bps_burst"
 	RBDQoSBurstReadBPSKey  = "conf_rbd_qos_read_bps_burst"
 	RBDQoSBurstWriteBPSKey = "conf_rbd_qos_write_bps_burst"
+
+	RBDQoSBurstSecondsIOPSKey      = "conf_rbd_qos_iops_burst_seconds"
+	RBDQoSBurstSecondsReadIOPSKey  = "conf_rbd_qos_read_iops_burst_seconds"
+	RBDQoSBurstSecondsWriteIOPSKey = "conf_rbd_qos_write_iops_burst_seconds"
+
+	RBDQoSBurstSecondsBPSKey      = "conf_rbd_qos_bps_burst_seconds"
+	RBDQoSBurstSecondsReadBPSKey  = "conf_rbd_qos_read_bps_burst_seconds"
+	RBDQoSBurstSecondsWriteBPSKey = "conf_rbd_qos_write_bps_burst_seconds"
+
+	RBDQoSScheduleTickMinKey = "conf_rbd_qos_schedule_tick_min"
+
+	// RBDQoSOwnerKey is the image metadata key recording the QoS rules set by
+	// the controller, which is ignored by librbd without the conf_ prefix.
+	RBDQoSOwnerKey = "qos_controller_owner"
 )
 
 var (
@@ -52,6 +68,16 @@ var (
 		vm.QoSBurstBPSKey:      RBDQoSBurstBPSKey,
 		vm.QoSBurstReadBPSKey:  RBDQoSBurstReadBPSKey,
 		vm.QoSBurstWriteBPSKey: RBDQoSBurstWriteBPSKey,
+
+		vm.QoSBurstSecondsIOPSKey:      RBDQoSBurstSecondsIOPSKey,
+		vm.QoSBurstSecondsReadIOPSKey:  RBDQoSBurstSecondsReadIOPSKey,
+		vm.QoSBurstSecondsWriteIOPSKey: RBDQoSBurstSecondsWriteIOPSKey,
+
+		vm.QoSBurstSecondsBPSKey:      RBDQoSBurstSecondsBPSKey,
+		vm.QoSBurstSecondsReadBPSKey:  RBDQoSBurstSecondsReadBPSKey,
+		vm.QoSBurstSecondsWriteBPSKey: RBDQoSBurstSecondsWriteBPSKey,
+
+		vm.QoSRBDScheduleTickMinKey: RBDQoSScheduleTickMinKey,
 	}
 
 	RBDQoSKeyMap = map[string]struct{}{
@@ -70,159 +96,447 @@ var (
 		RBDQoSBurstBPSKey:      {},
 		RBDQoSBurstReadBPSKey:  {},
 		RBDQoSBurstWriteBPSKey: {},
+
+		RBDQoSBurstSecondsIOPSKey:      {},
+		RBDQoSBurstSecondsReadIOPSKey:  {},
+		RBDQoSBurstSecondsWriteIOPSKey: {},
+
+		RBDQoSBurstSecondsBPSKey:      {},
+		RBDQoSBurstSecondsReadBPSKey:  {},
+		RBDQoSBurstSecondsWriteBPSKey: {},
+
+		RBDQoSScheduleTickMinKey: {},
 	}
 )
 
 type (
-	RBDQoSRules      map[string]string
+	RBDQoSRules map[string]string
+	// ClusterConfig is the config of connecting to a Ceph cluster.
+	ClusterConfig struct {
+		// ClusterID matches the clusterID in the volume attributes of the
+		// ceph-csi PVs.
+		ClusterID string `json:"cluster_id,omitempty" yaml:"clusterID,omitempty"`
+		Monitors  string `json:"monitors" yaml:"monitors"`
+		User      string `json:"user" yaml:"user"`
+		Key       string `json:"key" yaml:"key"`
+		// SecretRef references the Secret containing the Ceph user and key,
+		// which overrides the inline User and Key.
+		SecretRef *SecretRef `json:"secret_ref,omitempty" yaml:"secretRef,omitempty"`
+	}
 	RBDManagerConfig struct {
 		vm.CommonConfig `mapstructure:",squash"`
-		Monitors        string `json:"monitors" yaml:"monitors"`
-		User            string `json:"user" yaml:"user"`
-		Key             string `json:"key" yaml:"key"`
+		// ClusterConfig is the default cluster, which serves the PVs without
+		// clusterID or of unknown clusters if it has no ID.
+		ClusterConfig `mapstructure:",squash" yaml:",inline"`
+		// Clusters are the other clusters keyed by their clusterID.
+		Clusters []ClusterConfig `json:"clusters,omitempty" yaml:"clusters,omitempty"`
+		// CSIConfigRef references the ConfigMap of ceph-csi, the clusters
+		// not configured above are resolved from it and the Secrets of the
+		// PVs on demand.
+		CSIConfigRef *CSIConfigRef `json:"csi_config_ref,omitempty" yaml:"csiConfigRef,omitempty"`
+		// PoolDefaults are the QoS defaults of pools or RADOS namespaces,
+		// which are reconciled on each resync.
+		PoolDefaults []PoolDefaults `json:"pool_defaults,omitempty" yaml:"poolDefaults,omitempty"`
+		// HealthCheckInterval is the interval of pinging the Ceph clusters to
+		// detect broken connections.
+		HealthCheckInterval time.Duration `json:"health_check_interval,omitempty" yaml:"healthCheckInterval,omitempty"`
+		// OpTimeout bounds the monitor and OSD operations, 0 means no timeout.
+		OpTimeout time.Duration `json:"op_timeout,omitempty" yaml:"opTimeout,omitempty"`
+		// TakeOver regards all the QoS rules of the images as managed by the
+		// controller, so the rules set by hand are removed as well unless
+		// they are in the QoS settings.
+		TakeOver bool `json:"take_over,omitempty" yaml:"takeOver,omitempty"`
 	}
 	CephRBDManager struct {
-		conn *rados.Conn
+		// clusters are keyed by clusterID, the default cluster is keyed by
+		// "" as well.
+		clusters map[string]*cluster
+		// csiClusters are resolved from the ceph-csi config if referenced.
+		csiClusters *csiClusters
+		stopCh      chan struct{}
 		*RBDManagerConfig
 	}
 )
 
 func DefaultCephRBDConfig() *RBDManagerConfig {
 	return &RBDManagerConfig{
-		CommonConfig: vm.CommonConfig{Provisioner: DefaultCSIDriver},
+		CommonConfig:        vm.CommonConfig{Provisioner: DefaultCSIDriver},
+		HealthCheckInterval: DefaultHealthCheckInterval,
+		OpTimeout:           DefaultOpTimeout,
 	}
 }
 
-func NewCephRBDManager(cfg *RBDManagerConfig) (*CephRBDManager, error) {
+// NewCephRBDManager creates a Ceph RBD volume manager, the StorageClass lister
+// is required to resolve the ceph-csi Secrets if the ceph-csi config is
+// referenced.
+func NewCephRBDManager(cfg *RBDManagerConfig, kubeClient kubernetes.Interface, scLister storagelisters.StorageClassLister) (*CephRBDManager, error) {
 	// TODO: validate Ceph user & key & monitors
-
-	if err := os.MkdirAll(tmpKeyFileLocation, 0644); err != nil {
-		return nil, fmt.Errorf("creating a temporary keyfile directory failed: %w", err)
+	m := &CephRBDManager{
+		clusters:         make(map[string]*cluster),
+		RBDManagerConfig: cfg,
 	}
-
-	// Write user key to a temporary file.
-	tmpfile, err := os.CreateTemp(tmpKeyFileLocation, tmpKeyFileNamePrefix)
-	if err != nil {
-		return nil, fmt.Errorf("creating a temporary keyfile failed: %w", err)
+	add := func(cc *ClusterConfig) error {
+		if ref := cc.SecretRef; ref != nil && (ref.Name == "" || ref.Namespace == "") {
+			return fmt.Errorf("invalid credentials Secret reference of Ceph cluster %q: name and namespace are required", cc.ClusterID)
+		}
+		if _, ok := m.clusters[cc.ClusterID]; ok {
+			return fmt.Errorf("duplicate Ceph cluster %q", cc.ClusterID)
+		}
+		c := newCluster(cfg, cc, kubeClient)
+		m.clusters[cc.ClusterID] = c
+		if cc == &cfg.ClusterConfig && cc.ClusterID != "" {
+			m.clusters[""] = c
+		}
+		return nil
 	}
-	defer func() {
-		_ = os.Remove(tmpfile.Name())
-	}()
 
-	// Write the Ceph user key to the temporary file.
-	if _, err := tmpfile.WriteString(cfg.Key); err != nil {
-		return nil, fmt.Errorf("writing key to temporary keyfile failed: %w", err)
+	if cfg.Monitors != "" {
+		if err := add(&cfg.ClusterConfig); err != nil {
+			return nil, err
+		}
 	}
-	defer tmpfile.Close()
-
-	conn, err := rados.NewConnWithUser(cfg.User)
-	if err != nil {
-		return nil, fmt.Errorf("creating a new Ceph connection failed: %w", err)
+	if ref := cfg.CSIConfigRef; ref != nil {
+		if ref.Name == "" || ref.Namespace == "" {
+			return nil, fmt.Errorf("invalid ceph-csi config reference: name and namespace are required")
+		}
+		m.csiClusters = newCSIClusters(cfg, kubeClient, scLister)
 	}
-
-	args := []string{"-m", cfg.Monitors, "--keyfile=" + tmpfile.Name()}
-	if err := conn.ParseCmdLineArgs(args); err != nil {
-		return nil, fmt.Errorf("parsing cmdline args (%v) failed: %w", args, err)
+	for i := range cfg.Clusters {
+		if cfg.Clusters[i].ClusterID == "" {
+			return nil, fmt.Errorf("clusterID of Ceph cluster %s is required", cfg.Clusters[i].Monitors)
+		}
+		if err := add(&cfg.Clusters[i]); err != nil {
+			return nil, err
+		}
 	}
+	if err := m.validatePoolDefaults(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
-	return &CephRBDManager{
-		conn:             conn,
-		RBDManagerConfig: cfg,
-	}, nil
+// uniqueClusters returns the clusters sorted by ID, the default cluster is
+// listed once.
+func (m *CephRBDManager) uniqueClusters() []*cluster {
+	clusters := make([]*cluster, 0, len(m.clusters))
+	for id, c := range m.clusters {
+		if id == c.ClusterID {
+			clusters = append(clusters, c)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].ClusterID < clusters[j].ClusterID
+	})
+	return clusters
 }
 
-// Connect connects to the Ceph cluster.
+// Connect connects to all the Ceph clusters, and supervises the connections
+// which are rebuilt once they go bad or the credentials Secrets change. The
+// clusters failing to connect keep reconnecting in the background, so an
+// error is returned only if none of them is connected. The clusters in the
+// ceph-csi config are connected on demand.
 func (m *CephRBDManager) Connect() error {
-	if err := m.conn.Connect(); err != nil {
-		return fmt.Errorf("connecting Ceph cluster failed: %w", err)
+	clusters := m.uniqueClusters()
+	if m.csiClusters != nil {
+		m.stopCh = make(chan struct{})
+		if err := m.csiClusters.start(m.stopCh); err != nil {
+			m.Close()
+			return err
+		}
+		if len(clusters) == 0 {
+			return nil
+		}
+	}
+	if len(clusters) == 0 {
+		return fmt.Errorf("no Ceph cluster configured")
+	}
+
+	var errs []error
+	for _, c := range clusters {
+		if err := c.start(); err != nil {
+			klog.Errorf("Failed to connect to Ceph cluster %s: %v, reconnecting in the background", c, err)
+			errs = append(errs, fmt.Errorf("%s: %w", c, err))
+		}
+	}
+	if len(errs) == len(clusters) {
+		m.Close()
+		return utilerrors.NewAggregate(errs)
 	}
-	klog.V(4).Info("Connected to Ceph cluster")
 	return nil
 }
 
-// Close closes the connection to the Ceph cluster.
+// Close stops supervising and closes the connections to the Ceph clusters.
 func (m *CephRBDManager) Close() {
-	m.conn.Shutdown()
-	klog.V(4).Info("Disconnected to Ceph cluster")
+	for _, c := range m.uniqueClusters() {
+		c.stop()
+	}
+	if m.csiClusters != nil {
+		if m.stopCh != nil {
+			close(m.stopCh)
+			m.stopCh = nil
+		}
+		m.csiClusters.stop()
+	}
+}
+
+// Ping reports the connectivity to the configured Ceph clusters, which is
+// checked by the supervisors of the connections periodically, so that it
+// never waits for the clusters. The clusters resolved from the ceph-csi config
+// on demand are only logged, otherwise an unreachable cluster of a stray PV
+// keeps the controller not ready.
+func (m *CephRBDManager) Ping() error {
+	var errs []error
+	for _, c := range m.uniqueClusters() {
+		if err := c.health(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c, err))
+		}
+	}
+	if m.csiClusters != nil {
+		for _, c := range m.csiClusters.list() {
+			if err := c.health(); err != nil {
+				klog.V(2).Infof("Ceph cluster %s of the ceph-csi config is unhealthy: %v", c, err)
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
 }
 
-// getIOCtx creates and returns a new IOContext for the pool of PV.
-func (m *CephRBDManager) getIOCtx(pv *corev1.PersistentVolume) (*rados.IOContext, error) {
-	pool, ok := pv.Spec.CSI.VolumeAttributes["pool"]
-	if !ok {
-		return nil, fmt.Errorf("invalid PV missing pool in volumeAttributes")
+// getCluster returns the Ceph cluster of the PV by its clusterID, which is
+// looked up in the configured clusters first, then the ceph-csi config. The
+// PVs without clusterID or of unknown clusters are served by the default
+// cluster if it has no ID, which is unable to tell the clusters apart.
+func (m *CephRBDManager) getCluster(pv *corev1.PersistentVolume) (*cluster, error) {
+	id := pv.Spec.CSI.VolumeAttributes["clusterID"]
+	if c, ok := m.clusters[id]; ok {
+		return c, nil
 	}
-	return m.conn.OpenIOContext(pool)
+	if m.csiClusters != nil && id != "" {
+		return m.csiClusters.get(pv)
+	}
+	if c, ok := m.clusters[""]; ok && c.ClusterID == "" {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown Ceph cluster %q of PV %s", id, pv.Name)
 }
 
-// SetQoS configures the QoS settings for the RBD image of the PV.
-func (m *CephRBDManager) SetQoS(pv *corev1.PersistentVolume, settings vm.QoSSettings) (err error) {
+// withImage opens the RBD image of the PV and calls fn with it, the
+// connection is held from being replaced until done.
+func (m *CephRBDManager) withImage(pv *corev1.PersistentVolume, readOnly bool, fn func(img *rbd.Image, loc imageLocation) error) error {
 	if pv == nil {
 		return fmt.Errorf("PV is nil")
 	}
-
-	name, ok := pv.Spec.CSI.VolumeAttributes["imageName"]
-	if !ok {
-		return fmt.Errorf("invalid PV %s missing imageName in volumeAttributes", pv.Name)
+	loc, err := volumeLocation(pv)
+	if err != nil {
+		return vm.ErrInvalidArgs{Err: err}
+	}
+	c, err := m.getCluster(pv)
+	if err != nil {
+		if m.csiClusters != nil {
+			// The ceph-csi config or Secrets may be fixed later.
+			return err
+		}
+		// Retrying is of no use until the cluster is configured.
+		return vm.ErrInvalidArgs{Err: err}
 	}
 
-	ioctx, err := m.getIOCtx(pv)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ioctx, err := c.openIOContext(loc)
 	if err != nil {
 		return fmt.Errorf("failed to open IOContext for PV %s: %w", pv.Name, err)
 	}
 	defer ioctx.Destroy()
 
-	img, err := rbd.OpenImage(ioctx, name, rbd.NoSnapshot)
+	open := rbd.OpenImage
+	if readOnly {
+		open = rbd.OpenImageReadOnly
+	}
+	img, err := open(ioctx, loc.Image, rbd.NoSnapshot)
 	if err != nil {
-		return fmt.Errorf("failed to open image %s: %w", name, err)
+		return fmt.Errorf("failed to open image %s: %w", loc, err)
 	}
 	defer img.Close()
 
-	klog.V(4).Infof("Opened the RBD image %s of PV %s", name, pv.Name)
+	klog.V(4).Infof("Opened the RBD image %s of PV %s in Ceph cluster %s", loc, pv.Name, c)
+	return fn(img, loc)
+}
 
-	// Get the metadata of rbd image.
-	meta, err := img.ListMetadata()
-	if err != nil {
-		return fmt.Errorf("failed to list metadata of PV %s: %w", pv.Name, err)
-	}
-	cur := getQoSRulesFromMeta(meta) // the existing QoS rules
-	spec := rbdQoSRules(settings)    // the expected QoS rules
-
-	// Get the rules to be set.
-	set := calSet(cur, spec)
-	for k, v := range set {
-		// Add or update QoS rule equals to set metadata for RBD image.
-		if cerr := img.SetMetadata(k, v); cerr != nil {
-			err = fmt.Errorf("failed to set metadata %s=%s for PV %s: %w", k, v, pv.Name, cerr)
-			if isInvalidArgErr(cerr) {
-				// If the error is caused by invalid argument, return ErrInvalidArgs.
-				return vm.ErrInvalidArgs{Err: err}
+// SetQoS configures the QoS settings for the RBD image of the PV, the image is
+// left unchanged in the dry run.
+func (m *CephRBDManager) SetQoS(pv *corev1.PersistentVolume, settings vm.QoSSettings) (result *vm.QoSResult, err error) {
+	err = m.withImage(pv, false, func(img *rbd.Image, loc imageLocation) error {
+		// Get the metadata of rbd image.
+		meta, err := img.ListMetadata()
+		if err != nil {
+			return fmt.Errorf("failed to list metadata of PV %s: %w", pv.Name, err)
+		}
+		cur := getQoSRulesFromMeta(meta) // the existing QoS rules
+		spec := rbdQoSRules(settings)    // the expected QoS rules
+
+		// Get the rules to be set and removed, the removed ones are only the
+		// ones owned by the controller to preserve the rules set by hand.
+		owned, changed := m.ownedRules(meta, cur)
+		set := calSet(cur, spec)
+		remove := calRemove(owned, spec)
+		if disowned := calRemove(changed, spec); len(disowned) > 0 {
+			klog.Warningf("QoS rules %v of PV %s have been changed by hand since set, leave them as they are", disowned, pv.Name)
+		}
+		result = &vm.QoSResult{
+			Backend: BackendName,
+			Volume:  loc.String(),
+			Rules:   spec,
+			Changed: len(set) > 0 || len(remove) > 0,
+			Set:     set,
+			Removed: remove,
+		}
+		if m.DryRun {
+			for k, v := range set {
+				klog.Infof("[dry-run] would set metadata for PV %s: %s=%s", pv.Name, k, v)
+			}
+			for k, v := range remove {
+				klog.Infof("[dry-run] would remove metadata for PV %s: %s=%s", pv.Name, k, v)
+			}
+			return nil
+		}
+
+		// The owner tracks the rules owned by the controller as they are set
+		// or removed, the expected rules already in place are owned as well.
+		owner := make(RBDQoSRules, len(owned)+len(spec))
+		for k, v := range owned {
+			owner[k] = v
+		}
+		for k, v := range spec {
+			if cur[k] == v {
+				owner[k] = v
+			}
+		}
+		err = func() error {
+			for k, v := range set {
+				// Add or update QoS rule equals to set metadata for RBD image.
+				if cerr := img.SetMetadata(k, v); cerr != nil {
+					err := fmt.Errorf("failed to set metadata %s=%s for PV %s: %w", k, v, pv.Name, cerr)
+					if isInvalidArgErr(cerr) {
+						// If the error is caused by invalid argument, return ErrInvalidArgs.
+						return vm.ErrInvalidArgs{Err: err}
+					}
+					return err
+				}
+				owner[k] = v
+				klog.Infof("set metadata for PV %s: %s=%s", pv.Name, k, v)
+			}
+
+			for k, v := range remove {
+				// Remove QoS rule equals to remove metadata for RBD image.
+				if err := img.RemoveMetadata(k); err != nil {
+					return fmt.Errorf("failed to remove metadata %s=%s for PV %s: %w", k, v, pv.Name, err)
+				}
+				delete(owner, k)
+				klog.Infof("remove metadata for PV %s: %s=%s", pv.Name, k, v)
 			}
-			return
+			return nil
+		}()
+
+		// Record the rules owned by the controller, including the ones set
+		// before a failure, so that they are still removed later.
+		if oerr := setQoSOwnership(img, meta, owner); oerr != nil && err == nil {
+			err = fmt.Errorf("failed to record the owned QoS rules of PV %s: %w", pv.Name, oerr)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// metadataWriter writes the metadata of an image or a pool.
+type metadataWriter interface {
+	SetMetadata(key, value string) error
+	RemoveMetadata(key string) error
+}
+
+// setQoSOwnership records the owned QoS rules in the image or pool metadata if
+// they have changed, the record is removed if there is none.
+func setQoSOwnership(img metadataWriter, meta map[string]string, owner RBDQoSRules) error {
+	if len(owner) == 0 {
+		if _, ok := meta[RBDQoSOwnerKey]; !ok {
+			return nil
 		}
-		klog.Infof("set metadata for PV %s: %s=%s", pv.Name, k, v)
+		return img.RemoveMetadata(RBDQoSOwnerKey)
 	}
+	v := newQoSOwnership(owner).String()
+	if meta[RBDQoSOwnerKey] == v {
+		return nil
+	}
+	return img.SetMetadata(RBDQoSOwnerKey, v)
+}
 
-	// Get the rules to be removed.
-	remove := calRemove(cur, spec)
-	for k, v := range remove {
-		// Remove QoS rule equals to remove metadata for RBD image.
-		if err := img.RemoveMetadata(k); err != nil {
-			return fmt.Errorf("failed to remove metadata %s=%s for PV %s: %w", k, v, pv.Name, err)
+// GetQoS reads the QoS rules of the RBD image of the PV back from its metadata,
+// which are only the ones recorded as owned by the controller unless taking
+// over.
+func (m *CephRBDManager) GetQoS(pv *corev1.PersistentVolume) (result *vm.QoSResult, err error) {
+	err = m.withImage(pv, true, func(img *rbd.Image, loc imageLocation) error {
+		meta, err := img.ListMetadata()
+		if err != nil {
+			return fmt.Errorf("failed to list metadata of PV %s: %w", pv.Name, err)
+		}
+		// The owned rules changed by hand are read back as well, so that
+		// they are corrected if still expected.
+		owned, changed := m.ownedRules(meta, getQoSRulesFromMeta(meta))
+		for k, v := range changed {
+			owned[k] = v
 		}
-		klog.Infof("remove metadata for PV %s: %s=%s", pv.Name, k, v)
+		result = &vm.QoSResult{
+			Backend: BackendName,
+			Volume:  loc.String(),
+			Rules:   owned,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return result, nil
+}
 
-	return
+// ownedRules returns the existing QoS rules owned by the controller, which are
+// all of them if taking over, and the ones changed by hand since they were set
+// by the controller, which are no longer owned.
+func (m *CephRBDManager) ownedRules(meta map[string]string, cur RBDQoSRules) (owned, changed RBDQoSRules) {
+	if m.TakeOver {
+		return cur, RBDQoSRules{}
+	}
+	return getQoSOwnership(meta).split(cur)
 }
 
+// Validate validates the value of each QoS setting, all the invalid ones are
+// listed by the returned *vm.ValidationError.
 func (m *CephRBDManager) Validate(settings vm.QoSSettings) error {
-	for k, v := range settings {
-		if !isQoSValueValid(v) {
-			return fmt.Errorf("invalid value %q for QoS key %q", v, k)
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	verr := &vm.ValidationError{}
+	for _, k := range keys {
+		v := settings[k]
+		switch {
+		case vm.IsQoSValueUnlimited(v):
+			// Removes the setting.
+		case vm.IsQoSBurstSecondsKey(k):
+			if _, err := vm.ParseQoSDuration(v); err != nil {
+				verr.Add(k, v, err.Error())
+			}
+		case k == vm.QoSRBDScheduleTickMinKey:
+			if !isTickValid(v) {
+				verr.Add(k, v, "must be a positive number of milliseconds")
+			}
+		case !isQoSValueValid(v):
+			verr.Add(k, v, "")
 		}
 	}
-	return nil
+	return verr.ErrorOrNil()
 }
 
 // isInvalidArgErr checks if the error is caused by invalid argument
@@ -0,0 +1,221 @@
+package ceph
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newRBDPV(attrs map[string]string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           DefaultCSIDriver,
+					VolumeAttributes: attrs,
+				},
+			},
+		},
+	}
+}
+
+func TestCephRBDManager_getCluster(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       *RBDManagerConfig
+		clusterID string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "default cluster without ID",
+			cfg:       &RBDManagerConfig{ClusterConfig: ClusterConfig{Monitors: "mon-a"}},
+			clusterID: "unknown",
+			want:      "mon-a",
+		},
+		{
+			name: "by clusterID",
+			cfg: &RBDManagerConfig{
+				ClusterConfig: ClusterConfig{Monitors: "mon-a"},
+				Clusters: []ClusterConfig{
+					{ClusterID: "b", Monitors: "mon-b"},
+					{ClusterID: "c", Monitors: "mon-c"},
+				},
+			},
+			clusterID: "c",
+			want:      "c",
+		},
+		{
+			name: "default cluster with ID",
+			cfg: &RBDManagerConfig{
+				ClusterConfig: ClusterConfig{ClusterID: "a", Monitors: "mon-a"},
+			},
+			clusterID: "",
+			want:      "a",
+		},
+		{
+			name: "unknown cluster",
+			cfg: &RBDManagerConfig{
+				ClusterConfig: ClusterConfig{ClusterID: "a", Monitors: "mon-a"},
+				Clusters:      []ClusterConfig{{ClusterID: "b", Monitors: "mon-b"}},
+			},
+			clusterID: "c",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewCephRBDManager(tt.cfg, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := m.getCluster(newRBDPV(map[string]string{"clusterID": tt.clusterID}))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getCluster() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && c.String() != tt.want {
+				t.Errorf("getCluster() = %v, want %v", c, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCephRBDManager(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *RBDManagerConfig
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			cfg: &RBDManagerConfig{
+				ClusterConfig: ClusterConfig{ClusterID: "a", Monitors: "mon-a"},
+				Clusters:      []ClusterConfig{{ClusterID: "b", Monitors: "mon-b"}},
+			},
+		},
+		{
+			name: "duplicate clusterID",
+			cfg: &RBDManagerConfig{
+				ClusterConfig: ClusterConfig{ClusterID: "a", Monitors: "mon-a"},
+				Clusters:      []ClusterConfig{{ClusterID: "a", Monitors: "mon-b"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing clusterID",
+			cfg: &RBDManagerConfig{
+				Clusters: []ClusterConfig{{Monitors: "mon-b"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "pool defaults",
+			cfg: &RBDManagerConfig{
+				ClusterConfig: ClusterConfig{Monitors: "mon-a"},
+				PoolDefaults: []PoolDefaults{{
+					Pool:           "rbd",
+					RadosNamespace: "tenant-a",
+					QoS:            map[string]string{"qos-iops-limit": "1000", "qos-iops-burst-seconds": "30s"},
+				}},
+			},
+		},
+		{
+			name: "invalid pool defaults",
+			cfg: &RBDManagerConfig{
+				ClusterConfig: ClusterConfig{Monitors: "mon-a"},
+				PoolDefaults:  []PoolDefaults{{Pool: "rbd", QoS: map[string]string{"qos-iops-limit": "foo"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown pool defaults key",
+			cfg: &RBDManagerConfig{
+				ClusterConfig: ClusterConfig{Monitors: "mon-a"},
+				PoolDefaults:  []PoolDefaults{{Pool: "rbd", QoS: map[string]string{"qos-class": "gold"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid Secret reference",
+			cfg: &RBDManagerConfig{
+				ClusterConfig: ClusterConfig{Monitors: "mon-a", SecretRef: &SecretRef{Name: "csi-rbd-secret"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCephRBDManager(tt.cfg, nil, nil); (err != nil) != tt.wantErr {
+				t.Errorf("NewCephRBDManager() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPoolDefaults_String(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults PoolDefaults
+		want     string
+	}{
+		{name: "pool", defaults: PoolDefaults{Pool: "rbd"}, want: "rbd"},
+		{name: "RADOS namespace", defaults: PoolDefaults{Pool: "rbd", RadosNamespace: "tenant-a"}, want: "rbd/tenant-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.defaults.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCephRBDManager_Ping(t *testing.T) {
+	unreachable := errors.New("connection refused")
+	newHealthCluster := func(id string, err error) *cluster {
+		c := newCluster(DefaultCephRBDConfig(), &ClusterConfig{ClusterID: id}, nil)
+		c.setHealth(err)
+		return c
+	}
+	tests := []struct {
+		name     string
+		clusters []*cluster
+		csi      []*cluster
+		wantErr  bool
+	}{
+		{
+			name:     "healthy",
+			clusters: []*cluster{newHealthCluster("a", nil), newHealthCluster("b", nil)},
+		},
+		{
+			name:     "configured cluster unreachable",
+			clusters: []*cluster{newHealthCluster("a", nil), newHealthCluster("b", unreachable)},
+			wantErr:  true,
+		},
+		{
+			name:     "on-demand cluster unreachable",
+			clusters: []*cluster{newHealthCluster("a", nil)},
+			csi:      []*cluster{newHealthCluster("stray", unreachable)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &CephRBDManager{clusters: make(map[string]*cluster)}
+			for _, c := range tt.clusters {
+				m.clusters[c.ClusterID] = c
+			}
+			if tt.csi != nil {
+				m.csiClusters = &csiClusters{clusters: make(map[string]*cluster)}
+				for _, c := range tt.csi {
+					m.csiClusters.clusters[c.ClusterID] = c
+				}
+			}
+			if err := m.Ping(); (err != nil) != tt.wantErr {
+				t.Errorf("Ping() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package ceph
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// The keys of the Ceph user ID and key in the Secret, following the
+	// layout of the ceph-csi Secrets.
+	DefaultUserIDKey  = "userID"
+	DefaultUserKeyKey = "userKey"
+
+	secretTimeout = 30 * time.Second
+)
+
+// SecretRef references the Secret containing the Ceph credentials.
+type SecretRef struct {
+	Name       string `json:"name" yaml:"name"`
+	Namespace  string `json:"namespace" yaml:"namespace"`
+	UserIDKey  string `json:"user_id_key,omitempty" yaml:"userIDKey,omitempty"`
+	UserKeyKey string `json:"user_key_key,omitempty" yaml:"userKeyKey,omitempty"`
+}
+
+func (r *SecretRef) userIDKey() string {
+	if r.UserIDKey == "" {
+		return DefaultUserIDKey
+	}
+	return r.UserIDKey
+}
+
+func (r *SecretRef) userKeyKey() string {
+	if r.UserKeyKey == "" {
+		return DefaultUserKeyKey
+	}
+	return r.UserKeyKey
+}
+
+// credentials returns the Ceph user and key, which are read from the Secret if
+// referenced, or the config otherwise.
+func (c *cluster) credentials() (user, key string, err error) {
+	ref := c.SecretRef
+	if ref == nil {
+		return c.User, c.Key, nil
+	}
+	if c.kubeClient == nil {
+		return "", "", fmt.Errorf("no kube client to get Secret %s/%s", ref.Namespace, ref.Name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+	secret, err := c.kubeClient.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("getting Secret %s/%s failed: %w", ref.Namespace, ref.Name, err)
+	}
+
+	// The user in the config is used if the Secret has no user ID.
+	user = c.User
+	if v, ok := secret.Data[ref.userIDKey()]; ok {
+		user = string(v)
+	}
+	v, ok := secret.Data[ref.userKeyKey()]
+	if !ok {
+		return "", "", fmt.Errorf("Secret %s/%s missing key %q", ref.Namespace, ref.Name, ref.userKeyKey())
+	}
+	c.secretVersion.Store(secret.ResourceVersion)
+	return user, string(v), nil
+}
+
+// secretChanged tells if the Secret differs from the one of the credentials
+// in use.
+func (c *cluster) secretChanged(secret *corev1.Secret) bool {
+	version, _ := c.secretVersion.Load().(string)
+	return secret.ResourceVersion != version
+}
+
+// watchSecret watches the Secret of the Ceph credentials until the stop
+// channel is closed, and requests to reconnect once it changes.
+func (c *cluster) watchSecret(stopCh <-chan struct{}) {
+	ref := c.SecretRef
+	factory := informers.NewSharedInformerFactoryWithOptions(c.kubeClient, 0,
+		informers.WithNamespace(ref.Namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", ref.Name).String()
+		}))
+	informer := factory.Core().V1().Secrets().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			// The initial list delivers the Secret of the credentials in use.
+			if c.secretChanged(obj.(*corev1.Secret)) {
+				c.requestReconnect()
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			secret := new.(*corev1.Secret)
+			if !reflect.DeepEqual(old.(*corev1.Secret).Data, secret.Data) && c.secretChanged(secret) {
+				c.requestReconnect()
+			}
+		},
+	})
+	factory.Start(stopCh)
+}
+
+// requestReconnect requests the supervisor to rebuild the connection.
+func (c *cluster) requestReconnect() {
+	klog.Infof("Credentials Secret %s/%s of Ceph cluster %s changed", c.SecretRef.Namespace, c.SecretRef.Name, c)
+	select {
+	case c.reconnectCh <- struct{}{}:
+	default:
+		// A reconnection is pending already.
+	}
+}
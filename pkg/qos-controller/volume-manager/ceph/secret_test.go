@@ -0,0 +1,123 @@
+package ceph
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_cluster_credentials(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-rbd-secret", Namespace: "ceph", ResourceVersion: "1"},
+		Data: map[string][]byte{
+			DefaultUserIDKey:  []byte("kubernetes"),
+			DefaultUserKeyKey: []byte("secret-key"),
+			"adminKey":        []byte("admin-key"),
+		},
+	})
+	tests := []struct {
+		name     string
+		cfg      *ClusterConfig
+		wantUser string
+		wantKey  string
+		wantErr  bool
+	}{
+		{
+			name:     "inline",
+			cfg:      &ClusterConfig{User: "admin", Key: "inline-key"},
+			wantUser: "admin",
+			wantKey:  "inline-key",
+		},
+		{
+			name: "Secret",
+			cfg: &ClusterConfig{User: "admin", Key: "inline-key", SecretRef: &SecretRef{
+				Name: "csi-rbd-secret", Namespace: "ceph",
+			}},
+			wantUser: "kubernetes",
+			wantKey:  "secret-key",
+		},
+		{
+			name: "custom keys",
+			cfg: &ClusterConfig{User: "admin", SecretRef: &SecretRef{
+				Name: "csi-rbd-secret", Namespace: "ceph", UserIDKey: "adminID", UserKeyKey: "adminKey",
+			}},
+			wantUser: "admin",
+			wantKey:  "admin-key",
+		},
+		{
+			name: "missing key",
+			cfg: &ClusterConfig{SecretRef: &SecretRef{
+				Name: "csi-rbd-secret", Namespace: "ceph", UserKeyKey: "missing",
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Secret not found",
+			cfg: &ClusterConfig{SecretRef: &SecretRef{
+				Name: "missing", Namespace: "ceph",
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCluster(DefaultCephRBDConfig(), tt.cfg, kubeClient)
+			user, key, err := c.credentials()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("credentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if user != tt.wantUser || key != tt.wantKey {
+				t.Errorf("credentials() = %q, %q, want %q, %q", user, key, tt.wantUser, tt.wantKey)
+			}
+		})
+	}
+}
+
+func Test_cluster_secretChanged(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-rbd-secret", Namespace: "ceph", ResourceVersion: "1"},
+		Data:       map[string][]byte{DefaultUserKeyKey: []byte("secret-key")},
+	}
+	ref := &SecretRef{Name: "csi-rbd-secret", Namespace: "ceph"}
+	tests := []struct {
+		name    string
+		read    bool
+		version string
+		want    bool
+	}{
+		{
+			name:    "in use",
+			read:    true,
+			version: "1",
+			want:    false,
+		},
+		{
+			name:    "updated",
+			read:    true,
+			version: "2",
+			want:    true,
+		},
+		{
+			name:    "never read",
+			version: "1",
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCluster(DefaultCephRBDConfig(), &ClusterConfig{SecretRef: ref}, fake.NewSimpleClientset(secret))
+			if tt.read {
+				if _, _, err := c.credentials(); err != nil {
+					t.Fatalf("credentials() error = %v", err)
+				}
+			}
+			s := secret.DeepCopy()
+			s.ResourceVersion = tt.version
+			if got := c.secretChanged(s); got != tt.want {
+				t.Errorf("secretChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
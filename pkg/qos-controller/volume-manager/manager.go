@@ -12,15 +12,57 @@ func (e ErrInvalidArgs) Error() string {
 	return e.Err.Error()
 }
 
+// QoSResult describes the QoS rules applied to the volume by SetQoS.
+type QoSResult struct {
+	// Backend is the type of the storage backend, e.g. ceph-rbd.
+	Backend string
+	// Volume identifies the volume in the backend, e.g. the RBD image.
+	Volume string
+	// Rules are the QoS rules of the volume in the format of the backend.
+	Rules map[string]string
+	// Changed reports whether any rule has been set or removed.
+	Changed bool
+	// Set and Removed are the QoS rules set and removed by SetQoS, which are
+	// only planned in the dry run.
+	Set     map[string]string
+	Removed map[string]string
+}
+
 type VolumeManager interface {
 	Connect() error
 	Close()
-	SetQoS(pv *corev1.PersistentVolume, settings QoSSettings) error
+	SetQoS(pv *corev1.PersistentVolume, settings QoSSettings) (*QoSResult, error)
 	Validate(settings QoSSettings) error
 }
 
+// HealthChecker is optionally implemented by volume managers to check the
+// health of their backend.
+type HealthChecker interface {
+	// Ping checks the connectivity to the backend cheaply.
+	Ping() error
+}
+
+// Reconciler is optionally implemented by volume managers to reconcile the
+// backend-wide QoS settings periodically, e.g. the defaults of pools.
+type Reconciler interface {
+	// Reconcile resets the backend-wide QoS settings which have drifted.
+	Reconcile() error
+}
+
+// QoSReader is optionally implemented by volume managers to read the QoS
+// rules of volumes back from the backend, so that the rules changed outside
+// the controller are detected.
+type QoSReader interface {
+	// GetQoS returns the QoS rules of the volume of the PV in the format of
+	// the backend, Changed of the result is always false.
+	GetQoS(pv *corev1.PersistentVolume) (*QoSResult, error)
+}
+
 type CommonConfig struct {
 	Provisioner string `json:"provisioner" yaml:"provisioner"`
+	// DryRun computes the QoS rules to be set and removed without writing
+	// them to the backend, which is set by the controller.
+	DryRun bool `json:"-" yaml:"-"`
 }
 
 func (cc CommonConfig) HasProvisioner() bool {
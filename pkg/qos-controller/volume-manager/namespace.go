@@ -0,0 +1,103 @@
+package volumemanager
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// QoSMaxPrefix prefixes the annotation keys of the maximum QoS settings
+	// of a Namespace, e.g. pv.kubernetes.io/qos-max-iops-limit.
+	QoSMaxPrefix = QoSPrefix + "qos-max-"
+	// QoSMaxPolicyKey specifies how the settings exceeding the maximum of the
+	// Namespace are handled, which is either clamp (default) or reject.
+	QoSMaxPolicyKey = QoSPrefix + "qos-max-policy"
+
+	QoSMaxPolicyClamp  = "clamp"
+	QoSMaxPolicyReject = "reject"
+)
+
+// QoSMaxKey returns the Namespace annotation key of the maximum of the QoS key.
+func QoSMaxKey(key string) string {
+	return QoSMaxPrefix + strings.TrimPrefix(key, QoSPrefix+"qos-")
+}
+
+// GetNamespaceQoSDefaults extracts the default QoS settings from the Namespace annotations.
+func GetNamespaceQoSDefaults(ns *corev1.Namespace) QoSSettings {
+	return getQoSSettings(ns.Annotations)
+}
+
+// GetNamespaceQoSMax extracts the maximum QoS settings from the Namespace
+// annotations, keyed by the QoS keys.
+func GetNamespaceQoSMax(ns *corev1.Namespace) QoSSettings {
+	settings := make(QoSSettings)
+	for _, key := range QoSRateKeys {
+		if v, ok := ns.Annotations[QoSMaxKey(key)]; ok {
+			settings[key] = v
+		}
+	}
+	return settings
+}
+
+// GetNamespaceQoSMaxPolicy returns the policy applied to the settings
+// exceeding the maximum of the Namespace.
+func GetNamespaceQoSMaxPolicy(ns *corev1.Namespace) string {
+	if ns.Annotations[QoSMaxPolicyKey] == QoSMaxPolicyReject {
+		return QoSMaxPolicyReject
+	}
+	return QoSMaxPolicyClamp
+}
+
+// ClampQoSSettings caps the rate settings with the maximum settings. Unset and
+// unlimited limits having a maximum are set to the maximum since they are
+// unlimited otherwise, except the unset read/write limits bounded by their
+// total already. The unset and unlimited bursts are left as they are, since
+// they mean no burst above the limit.
+// It returns the capped settings and the keys whose value exceeds the maximum.
+// Invalid values are left as they are, so that they are reported by validation.
+// The capped settings may break the relations between them, e.g. a burst
+// lowered below its limit, so they need to be validated again.
+func ClampQoSSettings(settings, max QoSSettings) (QoSSettings, []string) {
+	clamped := MergeQoSSettings(settings)
+	var exceeded []string
+	for _, key := range QoSRateKeys {
+		limit, ok := max[key]
+		if !ok {
+			continue
+		}
+		maxValue, err := ParseQoSValue(limit)
+		if err != nil || maxValue == 0 {
+			// An unlimited maximum caps nothing.
+			continue
+		}
+		_, burst := qosBurstLimitKeys[key]
+		v, ok := settings[key]
+		if !ok {
+			if !burst && !boundedByTotal(clamped, key, maxValue) {
+				clamped[key] = limit
+			}
+			continue
+		}
+		value, err := ParseQoSValue(v)
+		if err != nil || (burst && value == 0) {
+			continue
+		}
+		if value == 0 || value > maxValue {
+			clamped[key] = limit
+			exceeded = append(exceeded, key)
+		}
+	}
+	return clamped, exceeded
+}
+
+// boundedByTotal tells if the read/write key is bounded by its total within
+// the maximum.
+func boundedByTotal(settings QoSSettings, key string, maxValue int64) bool {
+	totalKey, ok := qosTotalKeys[key]
+	if !ok {
+		return false
+	}
+	total, err := ParseQoSValue(settings[totalKey])
+	return err == nil && total > 0 && total <= maxValue
+}
@@ -0,0 +1,167 @@
+package volumemanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQoSMaxKey(t *testing.T) {
+	if got, want := QoSMaxKey(QoSLimitReadIOPSKey), "pv.kubernetes.io/qos-max-read-iops-limit"; got != want {
+		t.Errorf("QoSMaxKey() = %v, want %v", got, want)
+	}
+}
+
+func TestClampQoSSettings(t *testing.T) {
+	type args struct {
+		settings QoSSettings
+		max      QoSSettings
+	}
+	tests := []struct {
+		name         string
+		args         args
+		want         QoSSettings
+		wantExceeded []string
+	}{
+		{
+			name: "no max",
+			args: args{
+				settings: QoSSettings{QoSLimitIOPSKey: "100000"},
+			},
+			want: QoSSettings{QoSLimitIOPSKey: "100000"},
+		},
+		{
+			name: "within max",
+			args: args{
+				settings: QoSSettings{QoSLimitBPSKey: "10M"},
+				max:      QoSSettings{QoSLimitBPSKey: "1G"},
+			},
+			want: QoSSettings{QoSLimitBPSKey: "10M"},
+		},
+		{
+			name: "exceeds max",
+			args: args{
+				settings: QoSSettings{
+					QoSLimitIOPSKey: "100000",
+					QoSLimitBPSKey:  "2G",
+				},
+				max: QoSSettings{
+					QoSLimitIOPSKey: "5000",
+					QoSLimitBPSKey:  "1G",
+				},
+			},
+			want: QoSSettings{
+				QoSLimitIOPSKey: "5000",
+				QoSLimitBPSKey:  "1G",
+			},
+			wantExceeded: []string{QoSLimitIOPSKey, QoSLimitBPSKey},
+		},
+		{
+			name: "unset",
+			args: args{
+				settings: QoSSettings{},
+				max:      QoSSettings{QoSLimitIOPSKey: "5000"},
+			},
+			want: QoSSettings{QoSLimitIOPSKey: "5000"},
+		},
+		{
+			name: "unset read and write",
+			args: args{
+				settings: QoSSettings{QoSLimitBPSKey: "10M"},
+				max: QoSSettings{
+					QoSLimitReadIOPSKey: "3000",
+					QoSLimitWriteBPSKey: "5M",
+					QoSLimitReadBPSKey:  "20M",
+				},
+			},
+			want: QoSSettings{
+				QoSLimitBPSKey:      "10M",
+				QoSLimitReadIOPSKey: "3000",
+				QoSLimitWriteBPSKey: "5M",
+			},
+		},
+		{
+			name: "unset total",
+			args: args{
+				settings: QoSSettings{},
+				max: QoSSettings{
+					QoSLimitIOPSKey:      "5000",
+					QoSLimitWriteIOPSKey: "1000",
+					QoSLimitReadIOPSKey:  "8000",
+				},
+			},
+			want: QoSSettings{
+				QoSLimitIOPSKey:      "5000",
+				QoSLimitWriteIOPSKey: "1000",
+			},
+		},
+		{
+			name: "unset and unlimited bursts",
+			args: args{
+				settings: QoSSettings{
+					QoSLimitIOPSKey:     "1000",
+					QoSLimitReadIOPSKey: "500",
+					QoSBurstReadIOPSKey: "unlimited",
+				},
+				max: QoSSettings{
+					QoSBurstIOPSKey:      "10000",
+					QoSBurstReadIOPSKey:  "2000",
+					QoSBurstWriteIOPSKey: "2000",
+					QoSBurstBPSKey:       "20M",
+				},
+			},
+			want: QoSSettings{
+				QoSLimitIOPSKey:     "1000",
+				QoSLimitReadIOPSKey: "500",
+				QoSBurstReadIOPSKey: "unlimited",
+			},
+		},
+		{
+			name: "unlimited",
+			args: args{
+				settings: QoSSettings{
+					QoSLimitIOPSKey: "unlimited",
+					QoSLimitBPSKey:  "0",
+				},
+				max: QoSSettings{
+					QoSLimitIOPSKey: "5000",
+					QoSLimitBPSKey:  "unlimited",
+				},
+			},
+			want: QoSSettings{
+				QoSLimitIOPSKey: "5000",
+				QoSLimitBPSKey:  "0",
+			},
+			wantExceeded: []string{QoSLimitIOPSKey},
+		},
+		{
+			name: "invalid",
+			args: args{
+				settings: QoSSettings{QoSLimitIOPSKey: "foo"},
+				max:      QoSSettings{QoSLimitIOPSKey: "5000", QoSLimitBPSKey: "bar"},
+			},
+			want: QoSSettings{QoSLimitIOPSKey: "foo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotExceeded := ClampQoSSettings(tt.args.settings, tt.args.max)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ClampQoSSettings() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(gotExceeded, tt.wantExceeded) {
+				t.Errorf("ClampQoSSettings() gotExceeded = %v, want %v", gotExceeded, tt.wantExceeded)
+			}
+		})
+	}
+}
+
+func TestClampQoSSettings_relations(t *testing.T) {
+	settings := QoSSettings{
+		QoSLimitIOPSKey: "1000",
+		QoSBurstIOPSKey: "2000",
+	}
+	clamped, _ := ClampQoSSettings(settings, QoSSettings{QoSBurstIOPSKey: "500"})
+	if _, err := ValidateQoSRelations(clamped); err == nil {
+		t.Errorf("ValidateQoSRelations() of clamped settings %v error = nil, want burst less than limit", clamped)
+	}
+}
@@ -0,0 +1,66 @@
+package volumemanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// QoSObservedKey is the PVC annotation key recording the QoS rules applied
+// to the volume by the controller.
+const QoSObservedKey = QoSPrefix + "qos-observed"
+
+// ObservedQoS is the value of the QoSObservedKey annotation.
+type ObservedQoS struct {
+	Backend string            `json:"backend"`
+	Volume  string            `json:"volume"`
+	Rules   map[string]string `json:"rules,omitempty"`
+	// Hash is the hash of the rules, which changes with the rules only.
+	Hash string `json:"hash"`
+}
+
+// NewObservedQoS creates the observed QoS from the result of SetQoS.
+func NewObservedQoS(result *QoSResult) *ObservedQoS {
+	return &ObservedQoS{
+		Backend: result.Backend,
+		Volume:  result.Volume,
+		Rules:   result.Rules,
+		Hash:    HashRules(result.Rules),
+	}
+}
+
+// ParseObservedQoS decodes the observed QoS from the annotation value.
+func ParseObservedQoS(v string) (*ObservedQoS, error) {
+	observed := &ObservedQoS{}
+	if err := json.Unmarshal([]byte(v), observed); err != nil {
+		return nil, fmt.Errorf("invalid observed QoS %s: %v", QoSObservedKey, err)
+	}
+	return observed, nil
+}
+
+// String encodes the observed QoS as the annotation value.
+func (o *ObservedQoS) String() string {
+	// json.Marshal sorts the map keys, so the value is stable.
+	data, _ := json.Marshal(o)
+	return string(data)
+}
+
+// HashRules returns a short hash of the rules regardless of their order.
+func HashRules(rules map[string]string) string {
+	keys := make([]string, 0, len(rules))
+	for k := range rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{'='})
+		h.Write([]byte(rules[k]))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
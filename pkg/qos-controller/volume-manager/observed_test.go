@@ -0,0 +1,27 @@
+package volumemanager
+
+import (
+	"testing"
+)
+
+func TestObservedQoS_String(t *testing.T) {
+	o := NewObservedQoS(&QoSResult{
+		Backend: "ceph-rbd",
+		Volume:  "rbd/csi-vol-1",
+		Rules: map[string]string{
+			"conf_rbd_qos_iops_limit": "1000",
+			"conf_rbd_qos_bps_limit":  "10M",
+		},
+	})
+	want := `{"backend":"ceph-rbd","volume":"rbd/csi-vol-1","rules":{"conf_rbd_qos_bps_limit":"10M","conf_rbd_qos_iops_limit":"1000"},"hash":"` + o.Hash + `"}`
+	if got := o.String(); got != want {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+
+	if other := NewObservedQoS(&QoSResult{Rules: map[string]string{"conf_rbd_qos_iops_limit": "1000"}}); other.Hash == o.Hash {
+		t.Errorf("hash of different rules should differ: %v", o.Hash)
+	}
+	if empty := NewObservedQoS(&QoSResult{}); len(empty.Hash) != 16 {
+		t.Errorf("unexpected hash length: %v", empty.Hash)
+	}
+}
@@ -0,0 +1,127 @@
+package volumemanager
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// QoSIOPSPerGiBKey sets the IOPS limit in proportion to the capacity of
+	// the volume, which is bounded by the min and max keys.
+	QoSIOPSPerGiBKey    = QoSPrefix + "qos-iops-per-gib"
+	QoSIOPSPerGiBMinKey = QoSPrefix + "qos-iops-per-gib-min"
+	QoSIOPSPerGiBMaxKey = QoSPrefix + "qos-iops-per-gib-max"
+
+	// QoSBPSPerGiBKey sets the bps limit in proportion to the capacity of the
+	// volume, which is bounded by the min and max keys.
+	QoSBPSPerGiBKey    = QoSPrefix + "qos-bps-per-gib"
+	QoSBPSPerGiBMinKey = QoSPrefix + "qos-bps-per-gib-min"
+	QoSBPSPerGiBMaxKey = QoSPrefix + "qos-bps-per-gib-max"
+
+	gib = 1 << 30
+)
+
+// QoSPerGiBKeys is the list of the size-proportional QoS keys, which are
+// resolved into the limits by ResolveQoSPerGiB.
+var QoSPerGiBKeys = []string{
+	QoSIOPSPerGiBKey,
+	QoSIOPSPerGiBMinKey,
+	QoSIOPSPerGiBMaxKey,
+
+	QoSBPSPerGiBKey,
+	QoSBPSPerGiBMinKey,
+	QoSBPSPerGiBMaxKey,
+}
+
+// qosPerGiBRule resolves a size-proportional QoS setting into a limit.
+type qosPerGiBRule struct {
+	key      string
+	minKey   string
+	maxKey   string
+	limitKey string
+}
+
+var qosPerGiBRules = []qosPerGiBRule{
+	{key: QoSIOPSPerGiBKey, minKey: QoSIOPSPerGiBMinKey, maxKey: QoSIOPSPerGiBMaxKey, limitKey: QoSLimitIOPSKey},
+	{key: QoSBPSPerGiBKey, minKey: QoSBPSPerGiBMinKey, maxKey: QoSBPSPerGiBMaxKey, limitKey: QoSLimitBPSKey},
+}
+
+// MergeQoSLayers merges the QoS settings of the layers into a new one like
+// MergeQoSSettings, the latter layers override the former ones. The
+// size-proportional setting of a former layer is dropped if a latter layer
+// sets the matching limit, so that the limit is not overridden by it once
+// resolved by ResolveQoSPerGiB.
+func MergeQoSLayers(layers ...QoSSettings) QoSSettings {
+	merged := make(QoSSettings)
+	for _, layer := range layers {
+		for _, rule := range qosPerGiBRules {
+			if _, ok := layer[rule.limitKey]; ok {
+				delete(merged, rule.key)
+			}
+		}
+		for k, v := range layer {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// ResolveQoSPerGiB converts the size-proportional QoS settings into the limits
+// with the capacity of the volume, which is rounded up to whole GiBs (at least
+// 1 GiB). The limits are bounded by the min and max settings, which are
+// absolute values. The per-GiB settings override the limits unless they are 0
+// or unlimited.
+// The returned settings never contain the per-GiB keys, the invalid ones are
+// listed by the returned *ValidationError.
+func ResolveQoSPerGiB(settings QoSSettings, capacity resource.Quantity) (QoSSettings, error) {
+	resolved := MergeQoSSettings(settings)
+	for _, key := range QoSPerGiBKeys {
+		delete(resolved, key)
+	}
+
+	verr := &ValidationError{}
+	parse := func(key string) int64 {
+		v, ok := settings[key]
+		if !ok {
+			return 0
+		}
+		n, err := ParseQoSValue(v)
+		if err != nil {
+			verr.Add(key, v, "")
+		}
+		return n
+	}
+	gibs := (capacity.Value() + gib - 1) / gib
+	if gibs < 1 {
+		gibs = 1
+	}
+	for _, rule := range qosPerGiBRules {
+		perGiB, min, max := parse(rule.key), parse(rule.minKey), parse(rule.maxKey)
+		if min > 0 && max > 0 && min > max {
+			verr.Add(rule.minKey, settings[rule.minKey], fmt.Sprintf("must not be greater than %s %s", rule.maxKey, settings[rule.maxKey]))
+			continue
+		}
+		if perGiB == 0 {
+			continue
+		}
+		if perGiB > math.MaxInt64/gibs {
+			verr.Add(rule.key, settings[rule.key], fmt.Sprintf("overflows with the capacity %s", capacity.String()))
+			continue
+		}
+		limit := perGiB * gibs
+		if min > 0 && limit < min {
+			limit = min
+		}
+		if max > 0 && limit > max {
+			limit = max
+		}
+		resolved[rule.limitKey] = strconv.FormatInt(limit, 10)
+	}
+	if err := verr.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
@@ -0,0 +1,166 @@
+package volumemanager
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestResolveQoSPerGiB(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings QoSSettings
+		capacity string
+		want     QoSSettings
+		wantErr  bool
+	}{
+		{
+			name:     "no per-GiB settings",
+			settings: QoSSettings{QoSLimitIOPSKey: "1000"},
+			capacity: "10Gi",
+			want:     QoSSettings{QoSLimitIOPSKey: "1000"},
+		},
+		{
+			name: "proportional",
+			settings: QoSSettings{
+				QoSIOPSPerGiBKey: "50",
+				QoSBPSPerGiBKey:  "1Mi",
+			},
+			capacity: "10Gi",
+			want: QoSSettings{
+				QoSLimitIOPSKey: "500",
+				QoSLimitBPSKey:  "10485760",
+			},
+		},
+		{
+			name:     "rounded up",
+			settings: QoSSettings{QoSIOPSPerGiBKey: "50"},
+			capacity: "1500Mi",
+			want:     QoSSettings{QoSLimitIOPSKey: "100"},
+		},
+		{
+			name: "min and max",
+			settings: QoSSettings{
+				QoSIOPSPerGiBKey:    "50",
+				QoSIOPSPerGiBMinKey: "1000",
+				QoSBPSPerGiBKey:     "10M",
+				QoSBPSPerGiBMaxKey:  "100M",
+			},
+			capacity: "100Gi",
+			want: QoSSettings{
+				QoSLimitIOPSKey: "5000",
+				QoSLimitBPSKey:  "100000000",
+			},
+		},
+		{
+			name: "min floor",
+			settings: QoSSettings{
+				QoSIOPSPerGiBKey:    "50",
+				QoSIOPSPerGiBMinKey: "1000",
+			},
+			capacity: "1Gi",
+			want:     QoSSettings{QoSLimitIOPSKey: "1000"},
+		},
+		{
+			name: "override limit",
+			settings: QoSSettings{
+				QoSLimitIOPSKey:  "1000",
+				QoSIOPSPerGiBKey: "3",
+				QoSLimitBPSKey:   "100M",
+				QoSBPSPerGiBKey:  "unlimited",
+			},
+			capacity: "100Gi",
+			want: QoSSettings{
+				QoSLimitIOPSKey: "300",
+				QoSLimitBPSKey:  "100M",
+			},
+		},
+		{
+			name: "min greater than max",
+			settings: QoSSettings{
+				QoSIOPSPerGiBKey:    "50",
+				QoSIOPSPerGiBMinKey: "1000",
+				QoSIOPSPerGiBMaxKey: "100",
+			},
+			capacity: "10Gi",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid",
+			settings: QoSSettings{QoSBPSPerGiBKey: "foo"},
+			capacity: "10Gi",
+			wantErr:  true,
+		},
+		{
+			name:     "overflow",
+			settings: QoSSettings{QoSBPSPerGiBKey: "1E"},
+			capacity: "100Gi",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveQoSPerGiB(tt.settings, resource.MustParse(tt.capacity))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResolveQoSPerGiB() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveQoSPerGiB() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeQoSLayers(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers []QoSSettings
+		want   QoSSettings
+	}{
+		{
+			name: "limit overrides former per-GiB",
+			layers: []QoSSettings{
+				{QoSIOPSPerGiBKey: "50", QoSIOPSPerGiBMaxKey: "10000", QoSBPSPerGiBKey: "1Mi"},
+				{QoSLimitIOPSKey: "1000"},
+			},
+			want: QoSSettings{QoSIOPSPerGiBMaxKey: "10000", QoSLimitIOPSKey: "1000", QoSBPSPerGiBKey: "1Mi"},
+		},
+		{
+			name: "per-GiB overrides former limit",
+			layers: []QoSSettings{
+				{QoSLimitIOPSKey: "1000"},
+				{QoSIOPSPerGiBKey: "50"},
+			},
+			want: QoSSettings{QoSLimitIOPSKey: "1000", QoSIOPSPerGiBKey: "50"},
+		},
+		{
+			name: "same layer",
+			layers: []QoSSettings{
+				{QoSLimitIOPSKey: "1000", QoSIOPSPerGiBKey: "50"},
+			},
+			want: QoSSettings{QoSLimitIOPSKey: "1000", QoSIOPSPerGiBKey: "50"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeQoSLayers(tt.layers...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeQoSLayers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeQoSLayers_resolved(t *testing.T) {
+	// The absolute limit of the PVC wins over the per-GiB default.
+	storageClass := QoSSettings{QoSIOPSPerGiBKey: "50"}
+	pvc := QoSSettings{QoSLimitIOPSKey: "1000"}
+	got, err := ResolveQoSPerGiB(MergeQoSLayers(storageClass, pvc), resource.MustParse("100Gi"))
+	if err != nil {
+		t.Fatalf("ResolveQoSPerGiB() error = %v", err)
+	}
+	if want := (QoSSettings{QoSLimitIOPSKey: "1000"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ResolveQoSPerGiB() = %v, want %v", got, want)
+	}
+}
@@ -1,8 +1,15 @@
 package volumemanager
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // IOPS: number of I/Os per second (any type of I/O)
@@ -11,10 +18,14 @@ import (
 // bps: bytes per second (any type of I/O)
 // read bps: bytes per second read
 // write bps: bytes per second written
+// burst seconds: how long the burst lasts, in seconds or a duration like 30s
 
 const (
 	QoSPrefix = "pv.kubernetes.io/"
 
+	// QoSClassKey references the VolumeQoSClass of the PVC.
+	QoSClassKey = QoSPrefix + "qos-class"
+
 	// iops limit
 	QoSLimitIOPSKey      = QoSPrefix + "qos-iops-limit"
 	QoSLimitReadIOPSKey  = QoSPrefix + "qos-read-iops-limit"
@@ -34,35 +45,212 @@ const (
 	QoSBurstBPSKey      = QoSPrefix + "qos-bps-burst"
 	QoSBurstReadBPSKey  = QoSPrefix + "qos-read-bps-burst"
 	QoSBurstWriteBPSKey = QoSPrefix + "qos-write-bps-burst"
+
+	// iops burst seconds
+	QoSBurstSecondsIOPSKey      = QoSPrefix + "qos-iops-burst-seconds"
+	QoSBurstSecondsReadIOPSKey  = QoSPrefix + "qos-read-iops-burst-seconds"
+	QoSBurstSecondsWriteIOPSKey = QoSPrefix + "qos-write-iops-burst-seconds"
+
+	// bps burst seconds
+	QoSBurstSecondsBPSKey      = QoSPrefix + "qos-bps-burst-seconds"
+	QoSBurstSecondsReadBPSKey  = QoSPrefix + "qos-read-bps-burst-seconds"
+	QoSBurstSecondsWriteBPSKey = QoSPrefix + "qos-write-bps-burst-seconds"
+
+	// QoSRBDScheduleTickMinKey is passed through to the rbd_qos_schedule_tick_min
+	// of RBD images, in milliseconds.
+	QoSRBDScheduleTickMinKey = QoSPrefix + "qos-rbd-schedule-tick-min"
+
+	// QoSInjectedKey records the QoS annotations injected into the PVC by the
+	// mutating webhook, which is a JSON object of the injected values keyed
+	// by the annotation keys.
+	QoSInjectedKey = QoSPrefix + "qos-injected"
+
+	// QoSValueUnlimited explicitly removes the QoS setting, as well as 0.
+	QoSValueUnlimited = "unlimited"
+)
+
+// QoSRateKeys is the list of the QoS limit and burst keys, whose values are
+// IOPS or bps.
+var QoSRateKeys = []string{
+	QoSLimitIOPSKey,
+	QoSLimitReadIOPSKey,
+	QoSLimitWriteIOPSKey,
+
+	QoSBurstIOPSKey,
+	QoSBurstReadIOPSKey,
+	QoSBurstWriteIOPSKey,
+
+	QoSLimitBPSKey,
+	QoSLimitReadBPSKey,
+	QoSLimitWriteBPSKey,
+
+	QoSBurstBPSKey,
+	QoSBurstReadBPSKey,
+	QoSBurstWriteBPSKey,
+}
+
+// QoSBurstSecondsKeys is the list of the QoS burst seconds keys.
+var QoSBurstSecondsKeys = []string{
+	QoSBurstSecondsIOPSKey,
+	QoSBurstSecondsReadIOPSKey,
+	QoSBurstSecondsWriteIOPSKey,
+
+	QoSBurstSecondsBPSKey,
+	QoSBurstSecondsReadBPSKey,
+	QoSBurstSecondsWriteBPSKey,
+}
+
+// QoSKeys is the list of all QoS annotation keys.
+var QoSKeys = append(append(append([]string{}, QoSRateKeys...), QoSBurstSecondsKeys...), QoSRBDScheduleTickMinKey)
+
+// IsQoSBurstSecondsKey checks if the key is a QoS burst seconds key.
+func IsQoSBurstSecondsKey(key string) bool {
+	for _, k := range QoSBurstSecondsKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// AnnStorageProvisioner is the storage provisioner annotation of the PVC
+	// set by kube-controller-manager.
+	AnnStorageProvisioner = "volume.kubernetes.io/storage-provisioner"
+	// AnnBetaStorageProvisioner is the deprecated storage provisioner annotation.
+	AnnBetaStorageProvisioner = "volume.beta.kubernetes.io/storage-provisioner"
 )
 
+// GetPVCProvisioner returns the storage provisioner of the PVC from its
+// annotations, empty if it has not been provisioned yet.
+func GetPVCProvisioner(pvc *corev1.PersistentVolumeClaim) string {
+	for _, ann := range []string{AnnStorageProvisioner, AnnBetaStorageProvisioner} {
+		if provisioner, ok := pvc.Annotations[ann]; ok {
+			return provisioner
+		}
+	}
+	return ""
+}
+
 type QoSSettings map[string]string
 
-// GetPVCQoSSettings extracts the QoS settings from the PVC annotations.
+// GetPVCQoSSettings extracts the QoS settings from the PVC annotations. The
+// annotations injected by the mutating webhook are excluded unless they have
+// been changed since, as they only mirror the inherited defaults.
 func GetPVCQoSSettings(pvc *corev1.PersistentVolumeClaim) QoSSettings {
+	settings := getQoSSettings(pvc.Annotations)
+	for k, v := range GetPVCInjectedQoSSettings(pvc) {
+		if settings[k] == v {
+			delete(settings, k)
+		}
+	}
+	return settings
+}
+
+// GetPVCInjectedQoSSettings extracts the QoS settings injected by the mutating
+// webhook from the PVC annotations, none if the record is invalid.
+func GetPVCInjectedQoSSettings(pvc *corev1.PersistentVolumeClaim) QoSSettings {
+	v, ok := pvc.Annotations[QoSInjectedKey]
+	if !ok {
+		return nil
+	}
+	injected := make(QoSSettings)
+	if err := json.Unmarshal([]byte(v), &injected); err != nil {
+		return nil
+	}
+	return injected
+}
+
+// GetPVQoSSettings extracts the QoS settings from the PV annotations, e.g. of
+// a statically provisioned PV.
+func GetPVQoSSettings(pv *corev1.PersistentVolume) QoSSettings {
+	return getQoSSettings(pv.Annotations)
+}
+
+// GetStorageClassQoSSettings extracts the default QoS settings from the
+// StorageClass parameters and annotations, the annotations override the
+// parameters.
+func GetStorageClassQoSSettings(sc *storagev1.StorageClass) QoSSettings {
+	return MergeQoSLayers(getQoSSettings(sc.Parameters), getQoSSettings(sc.Annotations))
+}
+
+// getQoSSettings extracts the QoS settings from the given map.
+func getQoSSettings(m map[string]string) QoSSettings {
 	settings := make(QoSSettings)
+	for _, keys := range [][]string{QoSKeys, QoSPerGiBKeys} {
+		for _, key := range keys {
+			if v, ok := m[key]; ok {
+				settings[key] = v
+			}
+		}
+	}
+	return settings
+}
 
-	for _, key := range []string{
-		QoSLimitIOPSKey,
-		QoSLimitReadIOPSKey,
-		QoSLimitWriteIOPSKey,
-
-		QoSBurstIOPSKey,
-		QoSBurstReadIOPSKey,
-		QoSBurstWriteIOPSKey,
-
-		QoSLimitBPSKey,
-		QoSLimitReadBPSKey,
-		QoSLimitWriteBPSKey,
-
-		QoSBurstBPSKey,
-		QoSBurstReadBPSKey,
-		QoSBurstWriteBPSKey,
-	} {
-		if metav1.HasAnnotation(pvc.ObjectMeta, key) {
-			settings[key] = pvc.Annotations[key]
+// MergeQoSSettings merges multiple QoS settings into a new one, the values in
+// the latter settings override the former ones.
+func MergeQoSSettings(settings ...QoSSettings) QoSSettings {
+	merged := make(QoSSettings)
+	for _, s := range settings {
+		for k, v := range s {
+			merged[k] = v
 		}
 	}
+	return merged
+}
+
+// IsQoSValueUnlimited checks if the QoS value is 0 or "unlimited", which
+// explicitly removes the QoS setting from the volume.
+func IsQoSValueUnlimited(v string) bool {
+	if v == QoSValueUnlimited {
+		return true
+	}
+	n, err := ParseQoSValue(v)
+	return err == nil && n == 0
+}
 
-	return settings
+// ParseQoSValue parses the QoS value in the Kubernetes quantity syntax into a
+// whole number of I/Os or bytes per second, e.g. 100, 1.5G, 512K or 100Mi. The
+// decimal suffixes are k (or K), M, G, T, P and E, and the binary ones are Ki,
+// Mi, Gi, Ti, Pi and Ei. "unlimited" is parsed as 0.
+func ParseQoSValue(v string) (int64, error) {
+	if v == QoSValueUnlimited {
+		return 0, nil
+	}
+	s := v
+	if strings.HasSuffix(s, "K") {
+		// K is the common alias of the decimal suffix k.
+		s = strings.TrimSuffix(s, "K") + "k"
+	}
+	q, err := resource.ParseQuantity(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid QoS value %q", v)
+	}
+	if q.Sign() < 0 {
+		return 0, fmt.Errorf("invalid QoS value %q: must not be negative", v)
+	}
+	n, ok := q.AsInt64()
+	if !ok {
+		return 0, fmt.Errorf("invalid QoS value %q: must be a whole number within range", v)
+	}
+	return n, nil
+}
+
+// ParseQoSDuration parses the QoS duration value into seconds, which is a
+// number of seconds or a duration of whole seconds like 30s or 2m.
+func ParseQoSDuration(v string) (int64, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if n < 1 {
+			return 0, fmt.Errorf("invalid QoS duration %q: must be at least 1 second", v)
+		}
+		return n, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid QoS duration %q", v)
+	}
+	if d < time.Second || d%time.Second != 0 {
+		return 0, fmt.Errorf("invalid QoS duration %q: must be whole seconds of at least 1 second", v)
+	}
+	return int64(d / time.Second), nil
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -52,6 +53,26 @@ func TestGetPVCQoSSettings(t *testing.T) {
 				QoSLimitWriteIOPSKey: "1",
 			},
 		},
+		{
+			name: "injected",
+			args: args{
+				pvc: &corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{
+							QoSLimitIOPSKey:     "1000",
+							QoSLimitReadIOPSKey: "1",
+							QoSLimitBPSKey:      "10M",
+							QoSInjectedKey:      `{"pv.kubernetes.io/qos-iops-limit":"1000","pv.kubernetes.io/qos-bps-limit":"1M"}`,
+						},
+					},
+				},
+			},
+			// The injected bps limit has been changed by the user.
+			want: QoSSettings{
+				QoSLimitReadIOPSKey: "1",
+				QoSLimitBPSKey:      "10M",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -61,3 +82,185 @@ func TestGetPVCQoSSettings(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeQoSSettings(t *testing.T) {
+	type args struct {
+		settings []QoSSettings
+	}
+	tests := []struct {
+		name string
+		args args
+		want QoSSettings
+	}{
+		{
+			name: "none",
+			args: args{},
+			want: QoSSettings{},
+		},
+		{
+			name: "override",
+			args: args{
+				settings: []QoSSettings{
+					{
+						QoSLimitIOPSKey: "1",
+						QoSLimitBPSKey:  "1M",
+					},
+					nil,
+					{
+						QoSLimitIOPSKey:     "2",
+						QoSLimitReadIOPSKey: "2",
+					},
+				},
+			},
+			want: QoSSettings{
+				QoSLimitIOPSKey:     "2",
+				QoSLimitReadIOPSKey: "2",
+				QoSLimitBPSKey:      "1M",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeQoSSettings(tt.args.settings...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeQoSSettings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetStorageClassQoSSettings(t *testing.T) {
+	type args struct {
+		sc *storagev1.StorageClass
+	}
+	tests := []struct {
+		name string
+		args args
+		want QoSSettings
+	}{
+		{
+			name: "none",
+			args: args{
+				sc: &storagev1.StorageClass{
+					Parameters: map[string]string{
+						"pool": "rbd",
+					},
+				},
+			},
+			want: QoSSettings{},
+		},
+		{
+			name: "annotations override parameters",
+			args: args{
+				sc: &storagev1.StorageClass{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{
+							QoSLimitIOPSKey: "2",
+						},
+					},
+					Parameters: map[string]string{
+						"pool":          "rbd",
+						QoSLimitIOPSKey: "1",
+						QoSLimitBPSKey:  "1M",
+					},
+				},
+			},
+			want: QoSSettings{
+				QoSLimitIOPSKey: "2",
+				QoSLimitBPSKey:  "1M",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetStorageClassQoSSettings(tt.args.sc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetStorageClassQoSSettings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsQoSValueUnlimited(t *testing.T) {
+	tests := []struct {
+		v    string
+		want bool
+	}{
+		{v: "unlimited", want: true},
+		{v: "0", want: true},
+		{v: "0Mi", want: true},
+		{v: "1", want: false},
+		{v: "foo", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			if got := IsQoSValueUnlimited(tt.v); got != tt.want {
+				t.Errorf("IsQoSValueUnlimited() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQoSValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       string
+		want    int64
+		wantErr bool
+	}{
+		{name: "plain", v: "100", want: 100},
+		{name: "M", v: "10M", want: 10000000},
+		{name: "G", v: "1G", want: 1000000000},
+		{name: "T", v: "2T", want: 2000000000000},
+		{name: "K", v: "512K", want: 512000},
+		{name: "k", v: "512k", want: 512000},
+		{name: "fraction", v: "1.5G", want: 1500000000},
+		{name: "binary", v: "100Mi", want: 104857600},
+		{name: "zero", v: "0", want: 0},
+		{name: "unlimited", v: "unlimited", want: 0},
+		{name: "milli", v: "100m", wantErr: true},
+		{name: "not whole", v: "0.5", wantErr: true},
+		{name: "negative", v: "-1", wantErr: true},
+		{name: "invalid", v: "100MM", wantErr: true},
+		{name: "overflow", v: "99999999999T", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQoSValue(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseQoSValue() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseQoSValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQoSDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       string
+		want    int64
+		wantErr bool
+	}{
+		{name: "seconds", v: "30", want: 30},
+		{name: "duration", v: "2m", want: 120},
+		{name: "compound", v: "1m30s", want: 90},
+		{name: "zero", v: "0", wantErr: true},
+		{name: "fraction", v: "1500ms", wantErr: true},
+		{name: "sub-second", v: "500ms", wantErr: true},
+		{name: "rate", v: "10M", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQoSDuration(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseQoSDuration() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseQoSDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
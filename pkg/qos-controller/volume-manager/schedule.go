@@ -0,0 +1,151 @@
+package volumemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// QoSSchedulesKey is the PVC annotation key of the time-based QoS schedules,
+// which is a JSON array of schedules, e.g.
+// [{"schedule":"0 1 * * *","duration":"4h","timeZone":"Asia/Shanghai","qos":{"qos-iops-limit":"10000"}}]
+const QoSSchedulesKey = QoSPrefix + "qos-schedules"
+
+// QoSSchedule applies the QoS settings in the windows which start at the times
+// of the cron schedule and last for the duration.
+type QoSSchedule struct {
+	// Schedule is a standard cron expression of the starts of the windows.
+	Schedule string
+	// Duration is how long the windows last.
+	Duration time.Duration
+	// TimeZone is the IANA time zone of the schedule, UTC by default.
+	TimeZone string
+	// Settings are the QoS settings applied in the windows.
+	Settings QoSSettings
+}
+
+// qosScheduleJSON is the JSON format of QoSSchedule in the annotation, whose
+// QoS keys may omit the pv.kubernetes.io/ prefix.
+type qosScheduleJSON struct {
+	Schedule string            `json:"schedule"`
+	Duration string            `json:"duration"`
+	TimeZone string            `json:"timeZone,omitempty"`
+	QoS      map[string]string `json:"qos"`
+}
+
+// GetPVCQoSSchedules extracts the time-based QoS schedules from the PVC annotations.
+func GetPVCQoSSchedules(pvc *corev1.PersistentVolumeClaim) ([]QoSSchedule, error) {
+	v, ok := pvc.Annotations[QoSSchedulesKey]
+	if !ok {
+		return nil, nil
+	}
+	var raw []qosScheduleJSON
+	if err := json.Unmarshal([]byte(v), &raw); err != nil {
+		return nil, fmt.Errorf("invalid QoS schedules %s: %v", QoSSchedulesKey, err)
+	}
+	schedules := make([]QoSSchedule, 0, len(raw))
+	for _, r := range raw {
+		s := QoSSchedule{
+			Schedule: r.Schedule,
+			TimeZone: r.TimeZone,
+			Settings: make(QoSSettings),
+		}
+		if r.Duration != "" {
+			d, err := time.ParseDuration(r.Duration)
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration %q of QoS schedule %q", r.Duration, r.Schedule)
+			}
+			s.Duration = d
+		}
+		for k, v := range r.QoS {
+			if !strings.HasPrefix(k, QoSPrefix) {
+				k = QoSPrefix + k
+			}
+			s.Settings[k] = v
+		}
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+// Validate validates the schedule except the values of the QoS settings,
+// which are validated by the volume managers.
+func (s *QoSSchedule) Validate() error {
+	if _, _, err := s.parse(); err != nil {
+		return err
+	}
+	if s.Duration <= 0 {
+		return fmt.Errorf("invalid QoS schedule %q: duration must be positive", s.Schedule)
+	}
+	known := getQoSSettings(s.Settings)
+	for k := range s.Settings {
+		if _, ok := known[k]; !ok {
+			return fmt.Errorf("invalid QoS schedule %q: unknown QoS key %q", s.Schedule, k)
+		}
+	}
+	return nil
+}
+
+func (s *QoSSchedule) parse() (cron.Schedule, *time.Location, error) {
+	sched, err := cron.ParseStandard(s.Schedule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid QoS schedule %q: %v", s.Schedule, err)
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid time zone %q of QoS schedule %q: %v", s.TimeZone, s.Schedule, err)
+	}
+	return sched, loc, nil
+}
+
+// window reports whether a window of the schedule is active at the time, and
+// returns the next boundary, which is the end of the active window or the
+// start of the next one.
+func (s *QoSSchedule) window(now time.Time) (bool, time.Time, error) {
+	sched, loc, err := s.parse()
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	// The first start after now-duration is the start of the active window
+	// if it is not after now.
+	start := sched.Next(now.In(loc).Add(-s.Duration))
+	if start.IsZero() {
+		// The schedule never fires, e.g. on February 30th.
+		return false, time.Time{}, nil
+	}
+	if !start.After(now) {
+		return true, start.Add(s.Duration), nil
+	}
+	return false, start, nil
+}
+
+// ActiveQoSSettings returns the QoS settings of the schedules whose windows
+// are active at the time, the latter schedules override the former ones. It
+// also returns the next time any window starts or ends, which is zero if
+// there is none.
+func ActiveQoSSettings(schedules []QoSSchedule, now time.Time) (QoSSettings, time.Time, error) {
+	settings := make(QoSSettings)
+	var next time.Time
+	for i := range schedules {
+		active, boundary, err := schedules[i].window(now)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if active {
+			for k, v := range schedules[i].Settings {
+				settings[k] = v
+			}
+		}
+		if !boundary.IsZero() && (next.IsZero() || boundary.Before(next)) {
+			next = boundary
+		}
+	}
+	return settings, next, nil
+}
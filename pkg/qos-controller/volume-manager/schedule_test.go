@@ -0,0 +1,137 @@
+package volumemanager
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPVCQoSSchedules(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []QoSSchedule
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			value: `[{"schedule":"0 1 * * *","duration":"4h","timeZone":"Asia/Shanghai","qos":{"qos-iops-limit":"10000","pv.kubernetes.io/qos-bps-limit":"1Gi"}}]`,
+			want: []QoSSchedule{{
+				Schedule: "0 1 * * *",
+				Duration: 4 * time.Hour,
+				TimeZone: "Asia/Shanghai",
+				Settings: QoSSettings{
+					QoSLimitIOPSKey: "10000",
+					QoSLimitBPSKey:  "1Gi",
+				},
+			}},
+		},
+		{
+			name:    "invalid JSON",
+			value:   `{"schedule":"0 1 * * *"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid cron expression",
+			value:   `[{"schedule":"0 25 * * *","duration":"4h","qos":{"qos-iops-limit":"10000"}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "missing duration",
+			value:   `[{"schedule":"0 1 * * *","qos":{"qos-iops-limit":"10000"}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid time zone",
+			value:   `[{"schedule":"0 1 * * *","duration":"4h","timeZone":"Mars/Olympus","qos":{"qos-iops-limit":"10000"}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown key",
+			value:   `[{"schedule":"0 1 * * *","duration":"4h","qos":{"qos-foo":"10000"}}]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{QoSSchedulesKey: tt.value}},
+			}
+			got, err := GetPVCQoSSchedules(pvc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetPVCQoSSchedules() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetPVCQoSSchedules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActiveQoSSettings(t *testing.T) {
+	schedules := []QoSSchedule{
+		{
+			// 01:00-05:00 in UTC+8, i.e. 17:00-21:00 UTC.
+			Schedule: "0 1 * * *",
+			Duration: 4 * time.Hour,
+			TimeZone: "Asia/Shanghai",
+			Settings: QoSSettings{QoSLimitIOPSKey: "10000", QoSLimitBPSKey: "1G"},
+		},
+		{
+			// 20:00-22:00 UTC.
+			Schedule: "0 20 * * *",
+			Duration: 2 * time.Hour,
+			Settings: QoSSettings{QoSLimitIOPSKey: "20000"},
+		},
+	}
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		now      time.Time
+		want     QoSSettings
+		wantNext time.Time
+	}{
+		{
+			name:     "inactive",
+			now:      day.Add(12 * time.Hour),
+			want:     QoSSettings{},
+			wantNext: day.Add(17 * time.Hour),
+		},
+		{
+			name:     "at the start",
+			now:      day.Add(17 * time.Hour),
+			want:     QoSSettings{QoSLimitIOPSKey: "10000", QoSLimitBPSKey: "1G"},
+			wantNext: day.Add(20 * time.Hour),
+		},
+		{
+			name:     "overlapped",
+			now:      day.Add(20*time.Hour + 30*time.Minute),
+			want:     QoSSettings{QoSLimitIOPSKey: "20000", QoSLimitBPSKey: "1G"},
+			wantNext: day.Add(21 * time.Hour),
+		},
+		{
+			name:     "at the end",
+			now:      day.Add(22 * time.Hour),
+			want:     QoSSettings{},
+			wantNext: day.Add(41 * time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotNext, err := ActiveQoSSettings(schedules, tt.now)
+			if err != nil {
+				t.Fatalf("ActiveQoSSettings() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ActiveQoSSettings() got = %v, want %v", got, tt.want)
+			}
+			if !gotNext.Equal(tt.wantNext) {
+				t.Errorf("ActiveQoSSettings() next = %v, want %v", gotNext, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package volumemanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// qosBurstLimitKeys maps the QoS burst keys to their limit keys.
+var qosBurstLimitKeys = map[string]string{
+	QoSBurstIOPSKey:      QoSLimitIOPSKey,
+	QoSBurstReadIOPSKey:  QoSLimitReadIOPSKey,
+	QoSBurstWriteIOPSKey: QoSLimitWriteIOPSKey,
+	QoSBurstBPSKey:       QoSLimitBPSKey,
+	QoSBurstReadBPSKey:   QoSLimitReadBPSKey,
+	QoSBurstWriteBPSKey:  QoSLimitWriteBPSKey,
+}
+
+// qosTotalKeys maps the read and write QoS keys to their total keys.
+var qosTotalKeys = map[string]string{
+	QoSLimitReadIOPSKey:  QoSLimitIOPSKey,
+	QoSLimitWriteIOPSKey: QoSLimitIOPSKey,
+	QoSBurstReadIOPSKey:  QoSBurstIOPSKey,
+	QoSBurstWriteIOPSKey: QoSBurstIOPSKey,
+	QoSLimitReadBPSKey:   QoSLimitBPSKey,
+	QoSLimitWriteBPSKey:  QoSLimitBPSKey,
+	QoSBurstReadBPSKey:   QoSBurstBPSKey,
+	QoSBurstWriteBPSKey:  QoSBurstBPSKey,
+}
+
+// FieldError describes an invalid QoS setting.
+type FieldError struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("invalid value %q for QoS key %q", e.Value, e.Key)
+	}
+	return fmt.Sprintf("invalid value %q for QoS key %q: %s", e.Value, e.Key, e.Reason)
+}
+
+// ValidationError lists every invalid QoS setting.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Add adds an invalid QoS setting.
+func (e *ValidationError) Add(key, value, reason string) {
+	e.Errors = append(e.Errors, FieldError{Key: key, Value: value, Reason: reason})
+}
+
+// Keys returns the keys of the invalid QoS settings.
+func (e *ValidationError) Keys() []string {
+	keys := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		keys = append(keys, fe.Key)
+	}
+	return keys
+}
+
+// ErrorOrNil returns nil if there is no invalid QoS setting, so that a nil
+// *ValidationError is never returned as a non-nil error.
+func (e *ValidationError) ErrorOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateQoSRelations validates the relations between the QoS rate settings
+// whose values are valid individually: a burst must not be less than its
+// limit. The bursts without their limits, which take no effect, and the read
+// and write settings exceeding their total, which are capped by the total, are
+// returned as warnings.
+// Unlimited settings are regarded as unset, and invalid values are skipped
+// since they are reported by the volume managers.
+func ValidateQoSRelations(settings QoSSettings) ([]string, error) {
+	return validateQoSRelations(settings, true)
+}
+
+// ValidateQoSRelationsWithin validates the relations between the given QoS
+// settings only, like ValidateQoSRelations. The settings missing from them may
+// be inherited from elsewhere, so the bursts without limits are not reported.
+func ValidateQoSRelationsWithin(settings QoSSettings) ([]string, error) {
+	return validateQoSRelations(settings, false)
+}
+
+func validateQoSRelations(settings QoSSettings, complete bool) ([]string, error) {
+	values := make(map[string]int64)
+	for _, key := range QoSRateKeys {
+		v, ok := settings[key]
+		if !ok {
+			continue
+		}
+		if n, err := ParseQoSValue(v); err == nil && n > 0 {
+			values[key] = n
+		}
+	}
+
+	verr := &ValidationError{}
+	var warnings []string
+	for _, key := range QoSRateKeys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		if limitKey, ok := qosBurstLimitKeys[key]; ok {
+			limit, ok := values[limitKey]
+			switch {
+			case !ok:
+				if complete {
+					warnings = append(warnings, fmt.Sprintf("QoS key %q %s takes no effect without %s",
+						key, settings[key], limitKey))
+				}
+			case value < limit:
+				verr.Add(key, settings[key], fmt.Sprintf("burst must not be less than %s %s", limitKey, settings[limitKey]))
+			}
+		}
+		if totalKey, ok := qosTotalKeys[key]; ok {
+			if total, ok := values[totalKey]; ok && value > total {
+				warnings = append(warnings, fmt.Sprintf("QoS key %q %s exceeds %s %s and is capped by it",
+					key, settings[key], totalKey, settings[totalKey]))
+			}
+		}
+	}
+	return warnings, verr.ErrorOrNil()
+}
+
+// ValidateQoSSettings validates the value of each QoS setting with the volume
+// manager, and then the relations between them. If the volume manager returns
+// a *ValidationError, the invalid relations are appended to it so that every
+// invalid setting is listed.
+func ValidateQoSSettings(manager VolumeManager, settings QoSSettings) ([]string, error) {
+	warnings, err := ValidateQoSRelations(settings)
+	merr := manager.Validate(settings)
+	if merr == nil {
+		return warnings, err
+	}
+	verr, ok := merr.(*ValidationError)
+	if !ok || err == nil {
+		return warnings, merr
+	}
+	merged := &ValidationError{Errors: append(append([]FieldError{}, verr.Errors...), err.(*ValidationError).Errors...)}
+	return warnings, merged
+}
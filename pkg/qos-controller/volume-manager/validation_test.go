@@ -0,0 +1,134 @@
+package volumemanager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidateQoSRelations(t *testing.T) {
+	tests := []struct {
+		name         string
+		settings     QoSSettings
+		wantWarnings int
+		wantKeys     []string
+	}{
+		{
+			name: "valid",
+			settings: QoSSettings{
+				QoSLimitIOPSKey:     "1000",
+				QoSBurstIOPSKey:     "2000",
+				QoSLimitReadBPSKey:  "100Mi",
+				QoSBurstReadBPSKey:  "100Mi",
+				QoSLimitWriteBPSKey: "10M",
+			},
+		},
+		{
+			name: "burst less than limit",
+			settings: QoSSettings{
+				QoSLimitIOPSKey: "1000",
+				QoSBurstIOPSKey: "500",
+				QoSLimitBPSKey:  "1G",
+				QoSBurstBPSKey:  "100M",
+			},
+			wantKeys: []string{QoSBurstIOPSKey, QoSBurstBPSKey},
+		},
+		{
+			name: "burst without limit",
+			settings: QoSSettings{
+				QoSLimitReadIOPSKey: "unlimited",
+				QoSBurstReadIOPSKey: "500",
+				QoSBurstBPSKey:      "100M",
+			},
+			wantWarnings: 2,
+		},
+		{
+			name: "read and write exceed total",
+			settings: QoSSettings{
+				QoSLimitIOPSKey:      "1000",
+				QoSLimitReadIOPSKey:  "2000",
+				QoSLimitWriteIOPSKey: "3000",
+				QoSLimitBPSKey:       "1G",
+				QoSLimitReadBPSKey:   "1G",
+			},
+			wantWarnings: 2,
+		},
+		{
+			name: "invalid values skipped",
+			settings: QoSSettings{
+				QoSLimitIOPSKey: "foo",
+				QoSBurstIOPSKey: "bar",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := ValidateQoSRelations(tt.settings)
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("ValidateQoSRelations() warnings = %v, want %d", warnings, tt.wantWarnings)
+			}
+			if tt.wantKeys == nil {
+				if err != nil {
+					t.Errorf("ValidateQoSRelations() error = %v", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("ValidateQoSRelations() error = %v, want *ValidationError", err)
+			}
+			if got := verr.Keys(); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("ValidationError.Keys() = %v, want %v", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestValidateQoSRelationsWithin(t *testing.T) {
+	// The limit may be inherited, e.g. from the StorageClass.
+	warnings, err := ValidateQoSRelationsWithin(QoSSettings{QoSBurstIOPSKey: "500"})
+	if len(warnings) != 0 || err != nil {
+		t.Errorf("ValidateQoSRelationsWithin() = %v, %v, want no warnings and errors", warnings, err)
+	}
+	_, err = ValidateQoSRelationsWithin(QoSSettings{QoSLimitIOPSKey: "1000", QoSBurstIOPSKey: "500"})
+	if err == nil {
+		t.Errorf("ValidateQoSRelationsWithin() error = nil, want burst less than limit")
+	}
+}
+
+// fakeManager is a volume manager rejecting the value "foo".
+type fakeManager struct{}
+
+func (fakeManager) Connect() error { return nil }
+func (fakeManager) Close()         {}
+func (fakeManager) SetQoS(_ *corev1.PersistentVolume, _ QoSSettings) (*QoSResult, error) {
+	return &QoSResult{}, nil
+}
+func (fakeManager) Validate(settings QoSSettings) error {
+	verr := &ValidationError{}
+	for _, k := range QoSKeys {
+		if v, ok := settings[k]; ok && v == "foo" {
+			verr.Add(k, v, "")
+		}
+	}
+	return verr.ErrorOrNil()
+}
+
+func TestValidateQoSSettings(t *testing.T) {
+	settings := QoSSettings{
+		QoSLimitIOPSKey: "1000",
+		QoSBurstIOPSKey: "500",
+		QoSLimitBPSKey:  "foo",
+	}
+	_, err := ValidateQoSSettings(fakeManager{}, settings)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("ValidateQoSSettings() error = %v, want *ValidationError", err)
+	}
+	want := []string{QoSLimitBPSKey, QoSBurstIOPSKey}
+	if got := verr.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ValidationError.Keys() = %v, want %v", got, want)
+	}
+}
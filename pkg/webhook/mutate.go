@@ -0,0 +1,141 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// patchOperation is an operation of JSON patch.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// mutate injects the default QoS annotations resolved from the StorageClass
+// and the Namespace into the PVC being created. The annotations set by the
+// user are never overwritten. The injected ones are recorded by
+// vm.QoSInjectedKey, so the controller keeps merging the live defaults instead
+// of them unless they are changed by the user.
+func (s *Server) mutate(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Resource != pvcResource || req.Operation != admissionv1.Create {
+		return allowed()
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := json.Unmarshal(req.Object.Raw, pvc); err != nil {
+		return denied(http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("invalid PVC: %v", err))
+	}
+	// The namespace of the object may be omitted in the request.
+	if pvc.Namespace == "" {
+		pvc.Namespace = req.Namespace
+	}
+
+	provisioner, err := s.getProvisioner(ctx, pvc)
+	if err != nil {
+		return denied(http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+	}
+	if _, ok := s.volManagers[provisioner]; !ok {
+		return allowed()
+	}
+
+	defaults, err := s.getDefaultQoSSettings(ctx, pvc)
+	if err != nil {
+		return denied(http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+	}
+	if len(defaults) == 0 {
+		return allowed()
+	}
+	injected, err := json.Marshal(defaults)
+	if err != nil {
+		return denied(http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+	}
+	annotations := vm.MergeQoSSettings(defaults, vm.QoSSettings{vm.QoSInjectedKey: string(injected)})
+
+	patch, err := json.Marshal(annotationsPatch(pvc.Annotations, annotations))
+	if err != nil {
+		return denied(http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+// getDefaultQoSSettings returns the QoS settings to be injected into the PVC,
+// the annotations set by the user are excluded.
+func (s *Server) getDefaultQoSSettings(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (vm.QoSSettings, error) {
+	defaults, nsMax, err := s.getInheritedQoSSettings(ctx, pvc)
+	if err != nil {
+		return nil, err
+	}
+
+	user := vm.GetPVCQoSSettings(pvc)
+	effective, _ := vm.ClampQoSSettings(vm.MergeQoSLayers(defaults, user), nsMax)
+	for k := range user {
+		delete(effective, k)
+	}
+	return effective, nil
+}
+
+// getInheritedQoSSettings returns the default QoS settings of the PVC merged
+// from the StorageClass and the Namespace, and the maximum of the Namespace.
+func (s *Server) getInheritedQoSSettings(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (vm.QoSSettings, vm.QoSSettings, error) {
+	var scDefaults vm.QoSSettings
+	if name := pvc.Spec.StorageClassName; name != nil && *name != "" {
+		sc, err := s.kubeClient.StorageV1().StorageClasses().Get(ctx, *name, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, nil, fmt.Errorf("failed to get StorageClass %s: %v", *name, err)
+		}
+		if err == nil {
+			scDefaults = vm.GetStorageClassQoSSettings(sc)
+		}
+	}
+
+	ns, err := s.kubeClient.CoreV1().Namespaces().Get(ctx, pvc.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get Namespace %s: %v", pvc.Namespace, err)
+	}
+	return vm.MergeQoSLayers(scDefaults, vm.GetNamespaceQoSDefaults(ns)), vm.GetNamespaceQoSMax(ns), nil
+}
+
+// annotationsPatch returns the JSON patch adding the settings to the annotations.
+func annotationsPatch(annotations, settings map[string]string) []patchOperation {
+	if len(annotations) == 0 {
+		return []patchOperation{{Op: "add", Path: "/metadata/annotations", Value: settings}}
+	}
+
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	patch := make([]patchOperation, 0, len(keys))
+	for _, k := range keys {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + escapeJSONPointer(k),
+			Value: settings[k],
+		})
+	}
+	return patch
+}
+
+// escapeJSONPointer escapes the reference token of JSON pointer (RFC 6901).
+func escapeJSONPointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestServer_mutate(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		pvc       *corev1.PersistentVolumeClaim
+		want      []patchOperation
+	}{
+		{
+			name:      "update",
+			operation: admissionv1.Update,
+			pvc:       newPVC("rbd", nil),
+		},
+		{
+			name:      "unsupported provisioner",
+			operation: admissionv1.Create,
+			pvc:       newPVC("other", nil),
+		},
+		{
+			name:      "no annotations",
+			operation: admissionv1.Create,
+			pvc:       newPVC("rbd", nil),
+			want: []patchOperation{
+				{
+					Op:   "add",
+					Path: "/metadata/annotations",
+					Value: map[string]interface{}{
+						vm.QoSLimitIOPSKey:     "1000",
+						vm.QoSLimitReadIOPSKey: "500",
+						vm.QoSLimitBPSKey:      "10M",
+						vm.QoSLimitWriteBPSKey: "1M",
+						vm.QoSInjectedKey:      `{"pv.kubernetes.io/qos-bps-limit":"10M","pv.kubernetes.io/qos-iops-limit":"1000","pv.kubernetes.io/qos-read-iops-limit":"500","pv.kubernetes.io/qos-write-bps-limit":"1M"}`,
+					},
+				},
+			},
+		},
+		{
+			name:      "user annotations",
+			operation: admissionv1.Create,
+			pvc: newPVC("rbd", map[string]string{
+				vm.QoSLimitIOPSKey:     "1",
+				vm.QoSLimitWriteBPSKey: "100M",
+			}),
+			want: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations/pv.kubernetes.io~1qos-bps-limit", Value: "10M"},
+				{Op: "add", Path: "/metadata/annotations/pv.kubernetes.io~1qos-injected", Value: `{"pv.kubernetes.io/qos-bps-limit":"10M","pv.kubernetes.io/qos-read-iops-limit":"500"}`},
+				{Op: "add", Path: "/metadata/annotations/pv.kubernetes.io~1qos-read-iops-limit", Value: "500"},
+			},
+		},
+	}
+	s := newTestServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := json.Marshal(tt.pvc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &admissionv1.AdmissionRequest{
+				Resource:  pvcResource,
+				Operation: tt.operation,
+				Namespace: tt.pvc.Namespace,
+				Object:    runtime.RawExtension{Raw: raw},
+			}
+			resp := s.mutate(context.TODO(), req)
+			if !resp.Allowed {
+				t.Fatalf("mutate() denied: %v", resp.Result)
+			}
+			var got []patchOperation
+			if resp.Patch != nil {
+				if err := json.Unmarshal(resp.Patch, &got); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mutate() patch = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	"github.com/spf13/pflag"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultBindAddress = ":9443"
+	DefaultCertHost    = "localhost"
+
+	ValidatePath = "/validate"
+	MutatePath   = "/mutate"
+
+	certFileName = "tls.crt"
+	keyFileName  = "tls.key"
+)
+
+var pvcResource = metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "persistentvolumeclaims"}
+
+type (
+	Config struct {
+		BindAddress string `json:"bind_address,omitempty" yaml:"bindAddress,omitempty"`
+		// CertDir contains tls.crt and tls.key, a self-signed certificate is
+		// generated for CertHost if it is empty.
+		CertDir  string `json:"cert_dir,omitempty" yaml:"certDir,omitempty"`
+		CertHost string `json:"cert_host,omitempty" yaml:"certHost,omitempty"`
+	}
+	// Server is an admission webhook server validating the QoS annotations of
+	// PVCs and injecting the default ones.
+	Server struct {
+		kubeClient  kubernetes.Interface
+		volManagers map[string]vm.VolumeManager
+		*Config
+	}
+)
+
+func DefaultConfig() *Config {
+	return &Config{
+		BindAddress: DefaultBindAddress,
+		CertHost:    DefaultCertHost,
+	}
+}
+
+func (c *Config) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&c.BindAddress, "bind-address", "", c.BindAddress, "the address the webhook server listens on")
+	fs.StringVarP(&c.CertDir, "cert-dir", "", c.CertDir, "the directory containing tls.crt and tls.key, a self-signed certificate is generated if empty")
+	fs.StringVarP(&c.CertHost, "cert-host", "", c.CertHost, "the host name of the self-signed certificate")
+}
+
+func NewServer(kubeClient kubernetes.Interface, managers map[string]vm.VolumeManager, cfg *Config) *Server {
+	return &Server{
+		kubeClient:  kubeClient,
+		volManagers: managers,
+		Config:      cfg,
+	}
+}
+
+// Run serves the admission requests until the stop channel is closed.
+func (s *Server) Run(stopCh <-chan struct{}) error {
+	cert, err := s.loadCertificate()
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ValidatePath, func(w http.ResponseWriter, r *http.Request) {
+		s.serveAdmission(w, r, s.validate)
+	})
+	mux.HandleFunc(MutatePath, func(w http.ResponseWriter, r *http.Request) {
+		s.serveAdmission(w, r, s.mutate)
+	})
+	srv := &http.Server{
+		Addr:              s.BindAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	go func() {
+		<-stopCh
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	klog.Infof("Starting webhook server on %s", s.BindAddress)
+	if err := srv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	klog.Info("Shutting down webhook server")
+	return nil
+}
+
+// loadCertificate loads the serving certificate from the cert directory, or
+// generates a self-signed one.
+func (s *Server) loadCertificate() (tls.Certificate, error) {
+	if s.CertDir != "" {
+		return tls.LoadX509KeyPair(filepath.Join(s.CertDir, certFileName), filepath.Join(s.CertDir, keyFileName))
+	}
+
+	klog.Warningf("No cert dir specified, generating a self-signed certificate for %s", s.CertHost)
+	certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey(s.CertHost, nil, nil)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generating self-signed certificate failed: %w", err)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// serveAdmission decodes the AdmissionReview from the request, and writes
+// back the response of the admit function.
+func (s *Server) serveAdmission(w http.ResponseWriter, r *http.Request, admit func(context.Context, *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp := admit(r.Context(), review.Request)
+	resp.UID = review.Request.UID
+	review.Response = resp
+	review.Request = nil
+
+	data, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// validate validates the QoS annotations of the PVC with the volume manager
+// of its provisioner. On update, the PVC is only validated if its QoS
+// annotations have changed, so the annotations which have become invalid, e.g.
+// by the changed defaults, never block the other updates.
+func (s *Server) validate(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Resource != pvcResource {
+		return allowed()
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := json.Unmarshal(req.Object.Raw, pvc); err != nil {
+		return denied(http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("invalid PVC: %v", err))
+	}
+	if !pvc.DeletionTimestamp.IsZero() {
+		// Never block the PVC being deleted, e.g. removing its finalizers.
+		return allowed()
+	}
+	settings := vm.GetPVCQoSSettings(pvc)
+	if req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		old := &corev1.PersistentVolumeClaim{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return denied(http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("invalid PVC: %v", err))
+		}
+		if reflect.DeepEqual(vm.GetPVCQoSSettings(old), settings) &&
+			old.Annotations[vm.QoSSchedulesKey] == pvc.Annotations[vm.QoSSchedulesKey] {
+			return allowed()
+		}
+	}
+	schedules, err := vm.GetPVCQoSSchedules(pvc)
+	if err != nil {
+		return denied(http.StatusUnprocessableEntity, metav1.StatusReasonInvalid, fmt.Sprintf("invalid QoS annotations: %v", err))
+	}
+	if len(settings) == 0 && len(schedules) == 0 {
+		return allowed()
+	}
+
+	provisioner, err := s.getProvisioner(ctx, pvc)
+	if err != nil {
+		return denied(http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+	}
+	manager, ok := s.volManagers[provisioner]
+	if !ok {
+		// The volumes of other provisioners are not managed by us.
+		return allowed()
+	}
+	// The size-proportional settings are resolved with the requested capacity
+	// if the PVC has not been provisioned yet.
+	capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]
+	if !ok {
+		capacity = pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	}
+	// The settings of the schedules are validated as well, whether their
+	// windows are active or not.
+	for _, ss := range append([]vm.QoSSettings{settings}, scheduleSettings(schedules)...) {
+		resolved, err := vm.ResolveQoSPerGiB(ss, capacity)
+		if err == nil {
+			err = manager.Validate(resolved)
+		}
+		if err != nil {
+			return denied(http.StatusUnprocessableEntity, metav1.StatusReasonInvalid, fmt.Sprintf("invalid QoS annotations: %v", err))
+		}
+	}
+	if len(settings) == 0 {
+		return allowed()
+	}
+
+	// The relations are only validated between the annotations, since the
+	// settings inherited from the StorageClass, the Namespace, the
+	// VolumeQoSPolicy and the VolumeQoSClass are overridden by them and
+	// validated by the controller as a whole.
+	resolved, err := vm.ResolveQoSPerGiB(settings, capacity)
+	if err != nil {
+		return denied(http.StatusUnprocessableEntity, metav1.StatusReasonInvalid, fmt.Sprintf("invalid QoS annotations: %v", err))
+	}
+	warnings, err := vm.ValidateQoSRelationsWithin(resolved)
+	if err != nil {
+		return denied(http.StatusUnprocessableEntity, metav1.StatusReasonInvalid, fmt.Sprintf("invalid QoS annotations: %v", err))
+	}
+	resp := allowed()
+	resp.Warnings = warnings
+	return resp
+}
+
+// scheduleSettings returns the QoS settings of the schedules.
+func scheduleSettings(schedules []vm.QoSSchedule) []vm.QoSSettings {
+	settings := make([]vm.QoSSettings, 0, len(schedules))
+	for i := range schedules {
+		settings = append(settings, schedules[i].Settings)
+	}
+	return settings
+}
+
+// getProvisioner returns the storage provisioner of the PVC, which is read
+// from the StorageClass if the PVC has not been provisioned yet.
+func (s *Server) getProvisioner(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (string, error) {
+	if provisioner := vm.GetPVCProvisioner(pvc); provisioner != "" {
+		return provisioner, nil
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return "", nil
+	}
+	sc, err := s.kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get StorageClass %s: %v", *pvc.Spec.StorageClassName, err)
+	}
+	return sc.Provisioner, nil
+}
+
+func allowed() *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+func denied(code int32, reason metav1.StatusReason, msg string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Reason:  reason,
+			Message: msg,
+		},
+	}
+}
@@ -0,0 +1,263 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vm "github.com/crazytaxii/volume-qos-controller/pkg/qos-controller/volume-manager"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testProvisioner = "rbd.csi.ceph.com"
+
+// fakeManager is a volume manager accepting the numbers only.
+type fakeManager struct{}
+
+func (fakeManager) Connect() error { return nil }
+func (fakeManager) Close()         {}
+func (fakeManager) SetQoS(_ *corev1.PersistentVolume, _ vm.QoSSettings) (*vm.QoSResult, error) {
+	return &vm.QoSResult{}, nil
+}
+func (fakeManager) Validate(settings vm.QoSSettings) error {
+	for k, v := range settings {
+		if _, err := vm.ParseQoSValue(v); err != nil {
+			return fmt.Errorf("invalid value %q for QoS key %q", v, k)
+		}
+	}
+	return nil
+}
+
+func newTestServer() *Server {
+	kubeClient := fake.NewSimpleClientset(&storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "rbd",
+			Annotations: map[string]string{
+				vm.QoSLimitIOPSKey: "1000",
+				vm.QoSLimitBPSKey:  "100M",
+			},
+		},
+		Provisioner: testProvisioner,
+	}, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "demo",
+			Annotations: map[string]string{
+				vm.QoSLimitReadIOPSKey:               "500",
+				vm.QoSMaxKey(vm.QoSLimitBPSKey):      "10M",
+				vm.QoSMaxKey(vm.QoSLimitWriteBPSKey): "1M",
+			},
+		},
+	}, &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "other"},
+		Provisioner: "other.csi.k8s.io",
+	})
+	return NewServer(kubeClient, map[string]vm.VolumeManager{testProvisioner: fakeManager{}}, DefaultConfig())
+}
+
+func newPVC(storageClass string, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "datavol",
+			Namespace:   "demo",
+			Annotations: annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+		},
+	}
+}
+
+func TestServer_validate(t *testing.T) {
+	invalid := map[string]string{vm.QoSLimitIOPSKey: "foo"}
+	deleting := newPVC("rbd", invalid)
+	deleting.DeletionTimestamp = &metav1.Time{}
+	tests := []struct {
+		name         string
+		pvc          *corev1.PersistentVolumeClaim
+		old          *corev1.PersistentVolumeClaim
+		allowed      bool
+		wantWarnings int
+	}{
+		{
+			name:    "no QoS annotations",
+			pvc:     newPVC("rbd", nil),
+			allowed: true,
+		},
+		{
+			name:    "valid",
+			pvc:     newPVC("rbd", map[string]string{vm.QoSLimitReadIOPSKey: "1"}),
+			allowed: true,
+		},
+		{
+			name:    "burst with the limit of StorageClass",
+			pvc:     newPVC("rbd", map[string]string{vm.QoSBurstIOPSKey: "1"}),
+			allowed: true,
+		},
+		{
+			name: "burst less than limit",
+			pvc: newPVC("rbd", map[string]string{
+				vm.QoSLimitIOPSKey: "1000",
+				vm.QoSBurstIOPSKey: "1",
+			}),
+			allowed: false,
+		},
+		{
+			name: "per-GiB min greater than max",
+			pvc: newPVC("rbd", map[string]string{
+				vm.QoSIOPSPerGiBKey:    "1",
+				vm.QoSIOPSPerGiBMinKey: "1000",
+				vm.QoSIOPSPerGiBMaxKey: "1",
+			}),
+			allowed: false,
+		},
+		{
+			name: "invalid schedule settings",
+			pvc: newPVC("rbd", map[string]string{
+				vm.QoSSchedulesKey: `[{"schedule":"0 1 * * *","duration":"4h","qos":{"qos-iops-limit":"foo"}}]`,
+			}),
+			allowed: false,
+		},
+		{
+			name: "invalid schedule",
+			pvc: newPVC("rbd", map[string]string{
+				vm.QoSSchedulesKey: `[{"schedule":"foo","duration":"4h","qos":{"qos-iops-limit":"1"}}]`,
+			}),
+			allowed: false,
+		},
+		{
+			name: "read exceeds total",
+			pvc: newPVC("rbd", map[string]string{
+				vm.QoSLimitIOPSKey:     "1",
+				vm.QoSLimitReadIOPSKey: "1000",
+			}),
+			allowed:      true,
+			wantWarnings: 1,
+		},
+		{
+			name:    "invalid",
+			pvc:     newPVC("rbd", map[string]string{vm.QoSLimitIOPSKey: "foo"}),
+			allowed: false,
+		},
+		{
+			name:    "unsupported provisioner",
+			pvc:     newPVC("other", map[string]string{vm.QoSLimitIOPSKey: "foo"}),
+			allowed: true,
+		},
+		{
+			name:    "StorageClass not found",
+			pvc:     newPVC("missing", map[string]string{vm.QoSLimitIOPSKey: "foo"}),
+			allowed: true,
+		},
+		{
+			name: "update without QoS changes",
+			pvc: newPVC("rbd", map[string]string{
+				vm.QoSLimitIOPSKey:                "foo",
+				"pv.kubernetes.io/bind-completed": "yes",
+			}),
+			old:     newPVC("rbd", invalid),
+			allowed: true,
+		},
+		{
+			name:    "update with QoS changes",
+			pvc:     newPVC("rbd", map[string]string{vm.QoSLimitIOPSKey: "bar"}),
+			old:     newPVC("rbd", invalid),
+			allowed: false,
+		},
+		{
+			name: "update with schedule changes",
+			pvc: newPVC("rbd", map[string]string{
+				vm.QoSLimitIOPSKey: "foo",
+				vm.QoSSchedulesKey: `[{"schedule":"0 1 * * *","duration":"4h","qos":{"qos-iops-limit":"1"}}]`,
+			}),
+			old:     newPVC("rbd", invalid),
+			allowed: false,
+		},
+		{
+			name:    "deleting",
+			pvc:     deleting,
+			old:     newPVC("rbd", invalid),
+			allowed: true,
+		},
+		{
+			name: "provisioned",
+			pvc: newPVC("", map[string]string{
+				"volume.kubernetes.io/storage-provisioner": testProvisioner,
+				vm.QoSLimitIOPSKey:                         "foo",
+			}),
+			allowed: false,
+		},
+	}
+	s := newTestServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := json.Marshal(tt.pvc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &admissionv1.AdmissionRequest{
+				Resource:  pvcResource,
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			}
+			if tt.old != nil {
+				oldRaw, err := json.Marshal(tt.old)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: oldRaw}
+			}
+			got := s.validate(context.TODO(), req)
+			if got.Allowed != tt.allowed {
+				t.Errorf("validate() allowed = %v, want %v, result: %v", got.Allowed, tt.allowed, got.Result)
+			}
+			if len(got.Warnings) != tt.wantWarnings {
+				t.Errorf("validate() warnings = %v, want %d", got.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestServer_serveAdmission(t *testing.T) {
+	raw, err := json.Marshal(newPVC("rbd", map[string]string{vm.QoSLimitIOPSKey: "foo"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:      types.UID("uid"),
+			Resource: pvcResource,
+			Object:   runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer()
+	rec := httptest.NewRecorder()
+	s.serveAdmission(rec, httptest.NewRequest(http.MethodPost, ValidatePath, bytes.NewReader(body)), s.validate)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("serveAdmission() code = %v, want %v", rec.Code, http.StatusOK)
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(rec.Body.Bytes(), review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Response == nil || review.Response.UID != "uid" || review.Response.Allowed {
+		t.Errorf("serveAdmission() response = %+v, want denied response of uid", review.Response)
+	}
+}